@@ -0,0 +1,248 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/xgfone/apigateway/audit"
+	"github.com/xgfone/apigateway/backend"
+	"github.com/xgfone/apigw"
+)
+
+// The request and response types of the admin api, which are also used
+// to generate the OpenAPI document.
+
+// GetConfigRequest is the request to export the configuration.
+type GetConfigRequest struct {
+	Format string `query:"format" validate:"zero|oneof=json yaml"`
+}
+
+// ApplyConfigRequest is the request to apply the configuration,
+// whose body is GatewayConfig.
+type ApplyConfigRequest struct {
+	DryRun bool `query:"dry_run"`
+}
+
+// ApplyConfigResponse is the response of applying the configuration.
+type ApplyConfigResponse struct {
+	DryRun  bool           `json:"dry_run"`
+	Changes []ConfigChange `json:"changes"`
+}
+
+// ConfigChangesResponse is the response of the configuration changes.
+type ConfigChangesResponse struct {
+	Changes []ConfigChange `json:"changes"`
+}
+
+// GetConfigRevisionsRequest is the request to get the configuration revisions.
+//
+// If ID is ZERO, return all the revisions without the configurations.
+type GetConfigRevisionsRequest struct {
+	ID uint64 `query:"id"`
+}
+
+// ConfigRevisionsResponse is the response of the configuration revisions.
+type ConfigRevisionsResponse struct {
+	Revisions []ConfigRevision `json:"revisions"`
+}
+
+// DiffConfigRevisionsRequest is the request to diff two configuration revisions.
+//
+// If To is ZERO, diff with the current configuration.
+type DiffConfigRevisionsRequest struct {
+	From uint64 `query:"from" validate:"required"`
+	To   uint64 `query:"to"`
+}
+
+// RollbackConfigRevisionRequest is the request to roll back to the revision.
+type RollbackConfigRevisionRequest struct {
+	ID uint64 `json:"id" validate:"required"`
+}
+
+// GetAuditRecordsRequest is the request to query the audit records.
+//
+// Since and Until are the RFC3339 time.
+type GetAuditRecordsRequest struct {
+	Caller string `query:"caller"`
+	Method string `query:"method"`
+	Path   string `query:"path"`
+	Result string `query:"result" validate:"zero|oneof=success failure"`
+	Since  string `query:"since"`
+	Until  string `query:"until"`
+	Limit  int    `query:"limit" validate:"min=0"`
+}
+
+// AuditRecordsResponse is the response of the audit records.
+type AuditRecordsResponse struct {
+	Records []audit.Record `json:"records"`
+}
+
+// GetEventsRequest is the request to subscribe the events, which may be
+// resumed by the header "Last-Event-ID" instead.
+type GetEventsRequest struct {
+	LastEventID uint64 `query:"last_event_id"`
+}
+
+// HostQueryRequest is the request with the host in the query.
+type HostQueryRequest struct {
+	Host string `query:"host" validate:"zero|hostname_rfc1123"`
+}
+
+// HostRequest is the request with the host in the body.
+type HostRequest struct {
+	Host string `json:"host" validate:"zero|hostname_rfc1123"`
+}
+
+// HostsResponse is the response of the hosts.
+type HostsResponse struct {
+	Hosts []string `json:"hosts"`
+}
+
+// RoutesResponse is the response of the routes.
+type RoutesResponse struct {
+	Routes []apigw.Route `json:"routes"`
+}
+
+// RouteConfigsResponse is the response of the routes with their forwarder
+// settings but without the backends.
+type RouteConfigsResponse struct {
+	Routes []RouteConfig `json:"routes"`
+}
+
+// EndpointInfo is the information of the underlying endpoint.
+type EndpointInfo struct {
+	Type           string                        `json:"type"`
+	UserData       interface{}                   `json:"userdata"`
+	MetaData       map[string]interface{}        `json:"metadata"`
+	ReferenceCount int32                         `json:"reference_count"`
+	Drains         []backend.DrainState          `json:"drains,omitempty"`
+	Ejections      []backend.EjectionState       `json:"ejections,omitempty"`
+	Breakers       []backend.CircuitBreakerState `json:"breakers,omitempty"`
+	Pool           *backend.PoolStats            `json:"pool,omitempty"`
+
+	// Override is the administrative state of the endpoint, which is
+	// "up" or "down" if overridden.
+	Override string `json:"override,omitempty"`
+}
+
+// EndpointsResponse is the response of the underlying endpoints.
+type EndpointsResponse struct {
+	Endpoints []EndpointInfo `json:"endpoints"`
+}
+
+// EndpointOverrideRequest is the request to override the administrative
+// state of the endpoint, which is the string of the endpoint, such as
+// the url of the http backend "http://127.0.0.1:8080".
+//
+// For deleting, State is ignored and the override is cleared.
+type EndpointOverrideRequest struct {
+	Endpoint string `json:"endpoint" validate:"required"`
+	State    string `json:"state" validate:"zero|oneof=up down"`
+}
+
+// EndpointOverridesResponse is the response of the administrative states
+// of all the overridden endpoints.
+type EndpointOverridesResponse struct {
+	Overrides map[string]string `json:"overrides"`
+}
+
+// EndpointHistoryRequest is the request to get the health history of the
+// endpoint, which is the string of the endpoint, such as the url of the http
+// backend "http://127.0.0.1:8080".
+type EndpointHistoryRequest struct {
+	Endpoint string `query:"endpoint" validate:"required"`
+}
+
+// HealthCheckSettings is the default settings of the health check, which is
+// used by the backends without their own interval, timeout or retrynum.
+//
+// For updating, the omitted fields keep unchanged.
+type HealthCheckSettings struct {
+	// Interval is the interval between two probes of the backend.
+	Interval string `json:"interval"`
+
+	// Timeout is the timeout of a probe, which is not limited if "0s".
+	Timeout string `json:"timeout"`
+
+	// RetryNum is the number of the extra failed probes
+	// before the backend is marked as unhealthy.
+	RetryNum int `json:"retrynum" validate:"min=0"`
+
+	// Concurrency is the maximum number of the probes running concurrently,
+	// which is not limited if ZERO.
+	Concurrency int `json:"concurrency" validate:"min=0"`
+}
+
+// GetBackendGroupRequest is the request to get the backend group.
+//
+// If BackendGroup is empty, return the names of all the backend groups.
+type GetBackendGroupRequest struct {
+	Host         string `query:"host" validate:"zero|hostname_rfc1123"`
+	BackendGroup string `query:"backend_group"`
+}
+
+// BackendGroupNamesResponse is the response of the names of the backend groups.
+type BackendGroupNamesResponse struct {
+	BackendGroups []string `json:"backend_groups"`
+}
+
+// BackendGroupResponse is the response of the backend group.
+type BackendGroupResponse struct {
+	backend.GroupConfig
+	Backends   backend.Backends `json:"backends"`
+	Forwarders []string         `json:"forwarders"`
+}
+
+// BackendGroupBackends is the backends of the backend group.
+type BackendGroupBackends struct {
+	Name string `json:"name" validate:"required"`
+	backend.GroupConfig
+	Backends backend.Backends `json:"backends"`
+}
+
+// BackendGroupsRequest is the request to create or delete the backend groups.
+//
+// For deleting, if the backends of a backend group are empty, delete
+// the whole backend group. Or, only delete the backends. If Drain is not
+// empty, which is a duration such as "30s", drain the backends until
+// the deadline instead of deleting them at once.
+type BackendGroupsRequest struct {
+	Host          string                 `json:"host" validate:"zero|hostname_rfc1123"`
+	BackendGroups []BackendGroupBackends `json:"backend_groups"`
+	Drain         string                 `json:"drain,omitempty"`
+}
+
+// RouteQueryRequest is the request with the route in the query.
+type RouteQueryRequest struct {
+	Host   string `query:"host" validate:"zero|hostname_rfc1123"`
+	Path   string `query:"path" validate:"required"`
+	Method string `query:"method" validate:"required"`
+}
+
+// RouteBackendsRequest is the request to add or delete the route backends.
+//
+// For deleting, if Drain is not empty, which is a duration such as "30s",
+// drain the backends until the deadline instead of deleting them at once.
+type RouteBackendsRequest struct {
+	Host     string           `json:"host" validate:"zero|hostname_rfc1123"`
+	Path     string           `json:"path" validate:"required"`
+	Method   string           `json:"method" validate:"required"`
+	Backends backend.Backends `json:"backends"`
+	Drain    string           `json:"drain,omitempty"`
+}
+
+// BackendsResponse is the response of the backends.
+type BackendsResponse struct {
+	Backends backend.Backends `json:"backends"`
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xgfone/apigateway/backend"
+	"github.com/xgfone/apigateway/openapi"
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/goapp/log"
+	"github.com/xgfone/ship/v3"
+)
+
+// adminAPISpecs is the specifications of the admin apis, which is keyed by
+// "METHOD PATH" and built from the request and response types of the handlers.
+var adminAPISpecs = map[string]openapi.Spec{
+	"GET /v1/admin/openapi.json": {
+		Summary:  "Get the OpenAPI document of the admin api.",
+		Tags:     []string{"doc"},
+		Response: map[string]interface{}{},
+	},
+
+	"GET /v1/admin/config": {
+		Summary:  "Export the configuration of the gateway as JSON or YAML.",
+		Tags:     []string{"config"},
+		Query:    GetConfigRequest{},
+		Response: GatewayConfig{},
+	},
+	"PUT /v1/admin/config": {
+		Summary:  "Apply the whole configuration of the gateway, which also accepts YAML.",
+		Tags:     []string{"config"},
+		Query:    ApplyConfigRequest{},
+		Body:     GatewayConfig{},
+		Response: ApplyConfigResponse{},
+	},
+	"GET /v1/admin/config/revision": {
+		Summary:  "Get all the configuration revisions, or the revision by the id.",
+		Tags:     []string{"config"},
+		Query:    GetConfigRevisionsRequest{},
+		Response: ConfigRevisionsResponse{},
+	},
+	"GET /v1/admin/config/revision/diff": {
+		Summary:  "Diff two configuration revisions.",
+		Tags:     []string{"config"},
+		Query:    DiffConfigRevisionsRequest{},
+		Response: ConfigChangesResponse{},
+	},
+	"POST /v1/admin/config/revision/rollback": {
+		Summary:  "Roll the configuration back to the revision.",
+		Tags:     []string{"config"},
+		Body:     RollbackConfigRevisionRequest{},
+		Response: ConfigChangesResponse{},
+	},
+
+	"GET /v1/admin/audit": {
+		Summary:  "Query the recent audit records of the admin mutations.",
+		Tags:     []string{"audit"},
+		Query:    GetAuditRecordsRequest{},
+		Response: AuditRecordsResponse{},
+	},
+	"GET /v1/admin/events": {
+		Summary:             "Subscribe the configuration and health events as the server-sent events.",
+		Tags:                []string{"event"},
+		Query:               GetEventsRequest{},
+		Response:            Event{},
+		ResponseContentType: "text/event-stream",
+	},
+
+	"GET /v1/admin/host": {
+		Summary:  "Get all the hosts.",
+		Tags:     []string{"host"},
+		Response: HostsResponse{},
+	},
+	"POST /v1/admin/host": {
+		Summary: "Add the host.",
+		Tags:    []string{"host"},
+		Body:    HostRequest{},
+	},
+	"DELETE /v1/admin/host": {
+		Summary: "Delete the host.",
+		Tags:    []string{"host"},
+		Query:   HostQueryRequest{},
+	},
+
+	"GET /v1/admin/host/route": {
+		Summary:  "Get all the routes of the host with their forwarder settings.",
+		Tags:     []string{"route"},
+		Query:    HostQueryRequest{},
+		Response: RouteConfigsResponse{},
+	},
+	"POST /v1/admin/host/route": {
+		Summary: "Add the route with its forwarder settings and backends.",
+		Tags:    []string{"route"},
+		Body:    RouteConfig{},
+	},
+	"PUT /v1/admin/host/route": {
+		Summary: "Replace the forwarder settings and the backends of the route in place.",
+		Tags:    []string{"route"},
+		Body:    RouteConfig{},
+	},
+	"DELETE /v1/admin/host/route": {
+		Summary: "Delete the route.",
+		Tags:    []string{"route"},
+		Body:    apigw.Route{},
+	},
+
+	"GET /v1/admin/host/route/backend": {
+		Summary:  "Get all the backends of the route.",
+		Tags:     []string{"route"},
+		Query:    RouteQueryRequest{},
+		Response: BackendsResponse{},
+	},
+	"POST /v1/admin/host/route/backend": {
+		Summary: "Add the backends into the route.",
+		Tags:    []string{"route"},
+		Body:    RouteBackendsRequest{},
+	},
+	"DELETE /v1/admin/host/route/backend": {
+		Summary: "Delete or drain the backends from the route.",
+		Tags:    []string{"route"},
+		Body:    RouteBackendsRequest{},
+	},
+
+	"GET /v1/admin/host/backendgroup": {
+		Summary:  "Get the names of all the backend groups, or the backend group by the name.",
+		Tags:     []string{"backendgroup"},
+		Query:    GetBackendGroupRequest{},
+		Response: BackendGroupResponse{},
+	},
+	"POST /v1/admin/host/backendgroup": {
+		Summary: "Create the backend groups, or add the backends into them and update their policies and transports.",
+		Tags:    []string{"backendgroup"},
+		Body:    BackendGroupsRequest{},
+	},
+	"DELETE /v1/admin/host/backendgroup": {
+		Summary: "Delete the backend groups, or the backends from them, which may be drained.",
+		Tags:    []string{"backendgroup"},
+		Body:    BackendGroupsRequest{},
+	},
+
+	"GET /v1/admin/healthcheck": {
+		Summary:  "Get the default settings of the health check.",
+		Tags:     []string{"healthcheck"},
+		Response: HealthCheckSettings{},
+	},
+	"PUT /v1/admin/healthcheck": {
+		Summary: "Update the default settings of the health check, which take effect immediately but are not persisted.",
+		Tags:    []string{"healthcheck"},
+		Body:    HealthCheckSettings{},
+	},
+
+	"GET /v1/admin/underlying/hosts": {
+		Summary:  "Get all the hosts registered in the underlying router.",
+		Tags:     []string{"underlying"},
+		Response: HostsResponse{},
+	},
+	"GET /v1/admin/underlying/routes": {
+		Summary:  "Get all the routes of the host registered in the underlying router.",
+		Tags:     []string{"underlying"},
+		Query:    HostQueryRequest{},
+		Response: RoutesResponse{},
+	},
+	"GET /v1/admin/underlying/endpoints": {
+		Summary:  "Get all the endpoints checked by the health checker.",
+		Tags:     []string{"underlying"},
+		Response: EndpointsResponse{},
+	},
+	"GET /v1/admin/underlying/endpoints/history": {
+		Summary:  "Get the recent health transitions and the uptime percents of the endpoint.",
+		Tags:     []string{"underlying"},
+		Query:    EndpointHistoryRequest{},
+		Response: backend.HealthHistory{},
+	},
+	"GET /v1/admin/underlying/endpoints/override": {
+		Summary:  "Get the administrative states of all the overridden endpoints.",
+		Tags:     []string{"underlying"},
+		Response: EndpointOverridesResponse{},
+	},
+	"PUT /v1/admin/underlying/endpoints/override": {
+		Summary: "Mark the endpoint administratively up or down, overriding its health status.",
+		Tags:    []string{"underlying"},
+		Body:    EndpointOverrideRequest{},
+	},
+	"DELETE /v1/admin/underlying/endpoints/override": {
+		Summary: "Clear the administrative state of the endpoint to restore its health status.",
+		Tags:    []string{"underlying"},
+		Body:    EndpointOverrideRequest{},
+	},
+}
+
+var adminAPIDocument openapi.Document
+
+// checkAdminAPISpecs returns an error if any admin route has no specification
+// or any specification has no admin route.
+func checkAdminAPISpecs(routes []ship.RouteInfo) error {
+	var missings, stales []string
+	registered := make(map[string]struct{}, len(routes))
+	for _, route := range routes {
+		if strings.HasPrefix(route.Path, "/v1/admin/") {
+			key := route.Method + " " + route.Path
+			registered[key] = struct{}{}
+			if _, ok := adminAPISpecs[key]; !ok {
+				missings = append(missings, key)
+			}
+		}
+	}
+
+	for key := range adminAPISpecs {
+		if _, ok := registered[key]; !ok {
+			stales = append(stales, key)
+		}
+	}
+
+	switch {
+	case len(missings) > 0:
+		sort.Strings(missings)
+		return fmt.Errorf("no specifications of the admin apis: %s",
+			strings.Join(missings, ", "))
+	case len(stales) > 0:
+		sort.Strings(stales)
+		return fmt.Errorf("no admin apis of the specifications: %s",
+			strings.Join(stales, ", "))
+	default:
+		return nil
+	}
+}
+
+// initAdminAPIDocument generates the OpenAPI document from all the registered
+// admin routes, each of which must have the specification.
+func initAdminAPIDocument(r *ship.Ship) {
+	routes := r.Routes()
+	if err := checkAdminAPISpecs(routes); err != nil {
+		log.Fatal("the admin api document is out of date", log.E(err))
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path == routes[j].Path {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Path < routes[j].Path
+	})
+
+	g := openapi.NewGenerator("API Gateway Admin API", "v1")
+	for _, route := range routes {
+		if strings.HasPrefix(route.Path, "/v1/admin/") {
+			g.AddOperation(route.Method, route.Path,
+				adminAPISpecs[route.Method+" "+route.Path])
+		}
+	}
+	adminAPIDocument = g.Document()
+}
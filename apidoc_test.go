@@ -0,0 +1,42 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/xgfone/ship/v3"
+)
+
+func TestAdminAPISpecs(t *testing.T) {
+	r := ship.New()
+	initAdminRouter(r)
+	if err := checkAdminAPISpecs(r.Routes()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		routes []ship.RouteInfo
+	}{
+		{"missing", append(r.Routes(), ship.RouteInfo{Method: "GET", Path: "/v1/admin/missing"})},
+		{"stale", r.Routes()[1:]},
+	}
+	for _, tt := range tests {
+		if err := checkAdminAPISpecs(tt.routes); err == nil {
+			t.Errorf("%s: expect an error, but got nil", tt.name)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/xgfone/apigateway/audit"
+	"github.com/xgfone/gconf/v5"
+	"github.com/xgfone/go-tools/v7/lifecycle"
+	"github.com/xgfone/goapp/log"
+	"github.com/xgfone/ship/v3"
+)
+
+// maxAuditBodySize is the maximum size of the redacted request body kept
+// in the audit record.
+const maxAuditBodySize = 4 * 1024
+
+var auditOpts = []gconf.Opt{
+	gconf.StrOpt("sink", "The sink of the audit records, such as logger, file or none.").D("logger"),
+	gconf.StrOpt("file", "The path of the audit file if the sink is file."),
+	gconf.StrOpt("filesize", "The maximum size of the audit file to be rotated.").D("100M"),
+	gconf.IntOpt("filenum", "The maximum number of the rotated audit files.").D(100),
+	gconf.IntOpt("maxrecords", "The maximum number of the recent audit records kept in memory to be queried.").D(1000),
+}
+
+func init() { gconf.NewGroup("audit").RegisterOpts(auditOpts...) }
+
+var auditor = audit.NewAuditor(1000)
+
+// initAuditor initializes the auditor from the configuration.
+func initAuditor() {
+	group := gconf.Group("audit")
+	auditor = audit.NewAuditor(group.GetInt("maxrecords"))
+	lifecycle.Register(func() { auditor.Close() })
+
+	switch sink := group.GetString("sink"); sink {
+	case "logger":
+		auditor.AddSinks(audit.LoggerSink())
+	case "file":
+		s, err := audit.NewFileSink(group.GetString("file"),
+			group.GetString("filesize"), group.GetInt("filenum"))
+		if err != nil {
+			log.Fatal("fail to open the audit file", log.E(err))
+		}
+		auditor.AddSinks(s)
+	case "none", "":
+	default:
+		log.Fatalf("unknown audit sink '%s'", sink)
+	}
+}
+
+// auditAdminUpdate is a middleware to record every admin call to update
+// the gateway as an audit record.
+func auditAdminUpdate(next ship.Handler) ship.Handler {
+	return func(ctx *ship.Context) (err error) {
+		if ctx.Method() == http.MethodGet {
+			return next(ctx)
+		}
+
+		var body []byte
+		if req := ctx.Request(); req.Body != nil {
+			if body, err = ioutil.ReadAll(req.Body); err != nil {
+				return ship.ErrBadRequest.New(err)
+			}
+			req.Body.Close()
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		err = next(ctx)
+		recordAudit(ctx, audit.RedactBody(body, maxAuditBodySize), err)
+		return
+	}
+}
+
+// auditAuthFailure is a middleware to record every admin call rejected
+// by the authentication as an audit record, which does not keep the body.
+func auditAuthFailure(next ship.Handler) ship.Handler {
+	return func(ctx *ship.Context) (err error) {
+		if err = next(ctx); err != nil {
+			if he, ok := err.(ship.HTTPError); ok && he.Code == http.StatusUnauthorized {
+				recordAudit(ctx, "", err)
+			}
+		}
+		return
+	}
+}
+
+func recordAudit(ctx *ship.Context, body string, err error) {
+	r := audit.Record{
+		Time:     time.Now(),
+		Caller:   getCaller(ctx),
+		SourceIP: ctx.RealIP(),
+		Method:   ctx.Method(),
+		Path:     ctx.Path(),
+		Query:    ctx.QueryRawString(),
+		Body:     body,
+		Status:   ctx.StatusCode(),
+		Result:   audit.ResultSuccess,
+	}
+
+	if err != nil {
+		r.Result = audit.ResultFailure
+		if he, ok := err.(ship.HTTPError); ok {
+			r.Status = he.Code
+		} else {
+			r.Status = http.StatusInternalServerError
+		}
+		if r.Error = err.Error(); r.Error == "" {
+			r.Error = http.StatusText(r.Status)
+		}
+	} else if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+
+	if e := auditor.Record(r); e != nil {
+		log.Error("fail to write the audit record", log.E(e))
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit is used to record the mutations of the admin api.
+package audit
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Predefine the results of the audit record.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record is an audit record of the admin api call.
+type Record struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Caller   string    `json:"caller"`
+	SourceIP string    `json:"source_ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Body     string    `json:"body,omitempty"`
+	Status   int       `json:"status"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Filter is used to filter the audit records.
+//
+// The zero value of each field matches all the records.
+type Filter struct {
+	Caller string
+	Method string
+	Path   string // The prefix of the path.
+	Result string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // Only return the most recent Limit records.
+}
+
+// Match reports whether the record matches the filter.
+func (f Filter) Match(r Record) bool {
+	switch {
+	case f.Caller != "" && f.Caller != r.Caller,
+		f.Method != "" && !strings.EqualFold(f.Method, r.Method),
+		f.Path != "" && !strings.HasPrefix(r.Path, f.Path),
+		f.Result != "" && f.Result != r.Result,
+		!f.Since.IsZero() && r.Time.Before(f.Since),
+		!f.Until.IsZero() && r.Time.After(f.Until):
+		return false
+	default:
+		return true
+	}
+}
+
+// Auditor keeps the most recent audit records in memory to be queried,
+// and writes all the records into the sinks.
+type Auditor struct {
+	lock    sync.RWMutex
+	lastID  uint64
+	maxsize int
+	records []Record
+	sinks   []Sink
+}
+
+// NewAuditor returns a new auditor, which keeps the most recent maxsize
+// audit records in memory.
+func NewAuditor(maxsize int, sinks ...Sink) *Auditor {
+	return &Auditor{maxsize: maxsize, sinks: sinks}
+}
+
+// AddSinks adds the sinks to write the audit records into.
+func (a *Auditor) AddSinks(sinks ...Sink) {
+	a.lock.Lock()
+	a.sinks = append(a.sinks, sinks...)
+	a.lock.Unlock()
+}
+
+// Close closes all the sinks.
+func (a *Auditor) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, sink := range a.sinks {
+		sink.Close()
+	}
+	a.sinks = nil
+	return nil
+}
+
+// Record records the audit record, and returns the first error
+// to fail to write it into the sinks.
+func (a *Auditor) Record(r Record) (err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.lastID++
+	r.ID = a.lastID
+	if a.maxsize > 0 {
+		a.records = append(a.records, r)
+		if _len := len(a.records); _len > a.maxsize {
+			a.records = append([]Record{}, a.records[_len-a.maxsize:]...)
+		}
+	}
+
+	for _, sink := range a.sinks {
+		if e := sink.Write(r); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Query returns the recent audit records matching the filter,
+// which are sorted by the time from new to old.
+func (a *Auditor) Query(filter Filter) (records []Record) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	records = make([]Record, 0, 16)
+	for i := len(a.records) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(records) >= filter.Limit {
+			break
+		} else if filter.Match(a.records[i]) {
+			records = append(records, a.records[i])
+		}
+	}
+	return
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuditorQuery(t *testing.T) {
+	now := time.Now()
+	auditor := NewAuditor(4)
+	for _, r := range []Record{
+		{Time: now.Add(-4 * time.Minute), Caller: "discarded", Method: "GET", Path: "/v1/admin/host"},
+		{Time: now.Add(-3 * time.Minute), Caller: "admin", Method: "POST", Path: "/v1/admin/host", Result: "success"},
+		{Time: now.Add(-2 * time.Minute), Caller: "admin", Method: "DELETE", Path: "/v1/admin/route", Result: "failure"},
+		{Time: now.Add(-1 * time.Minute), Caller: "editor", Method: "POST", Path: "/v1/admin/route", Result: "success"},
+		{Time: now, Caller: "editor", Method: "PUT", Path: "/v1/admin/config", Result: "failure"},
+	} {
+		auditor.Record(r)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		ids    []uint64
+	}{
+		{"all", Filter{}, []uint64{5, 4, 3, 2}},
+		{"caller", Filter{Caller: "admin"}, []uint64{3, 2}},
+		{"method", Filter{Method: "post"}, []uint64{4, 2}},
+		{"path prefix", Filter{Path: "/v1/admin/route"}, []uint64{4, 3}},
+		{"result", Filter{Result: "failure"}, []uint64{5, 3}},
+		{"since", Filter{Since: now.Add(-90 * time.Second)}, []uint64{5, 4}},
+		{"until", Filter{Until: now.Add(-90 * time.Second)}, []uint64{3, 2}},
+		{"limit", Filter{Limit: 3}, []uint64{5, 4, 3}},
+		{"limit after match", Filter{Caller: "admin", Limit: 1}, []uint64{3}},
+		{"none", Filter{Caller: "discarded"}, nil},
+	}
+
+	for _, tt := range tests {
+		var ids []uint64
+		for _, r := range auditor.Query(tt.filter) {
+			ids = append(ids, r.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: expect the records %v, but got %v", tt.name, tt.ids, ids)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		maxsize int
+		expect  string
+	}{
+		{"empty", "", 0, ""},
+		{"non-JSON", "a=1&b=2", 0, "(non-JSON body of 7 bytes)"},
+		{"no secret", `{"host":"www.example.com"}`, 0, `{"host":"www.example.com"}`},
+		{
+			name:   "secret",
+			body:   `{"session":{"secret":"abc","secret_env":"ENV"},"key":"k","keys":1}`,
+			expect: `{"key":"******","keys":1,"session":{"secret":"******","secret_env":"******"}}`,
+		},
+		{
+			name:   "nested in array",
+			body:   `[{"Password":"p","api_key":"k"},{"name":"n"}]`,
+			expect: `[{"Password":"******","api_key":"******"},{"name":"n"}]`,
+		},
+		{"truncated", `{"host":"www.example.com"}`, 8, `{"host":...(truncated)`},
+	}
+
+	for _, tt := range tests {
+		if body := RedactBody([]byte(tt.body), tt.maxsize); body != tt.expect {
+			t.Errorf("%s: expect '%s', but got '%s'", tt.name, tt.expect, body)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RedactedValue is the value to replace the sensitive values.
+const RedactedValue = "******"
+
+// SensitiveKeys is the list of the substrings of the keys, the values of which
+// are the secrets or credentials and redacted by RedactBody.
+var SensitiveKeys = []string{
+	"secret",
+	"password",
+	"passwd",
+	"token",
+	"credential",
+	"authorization",
+	"cookie",
+	"private_key",
+	"api_key",
+	"apikey",
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if key == "key" {
+		return true
+	}
+
+	for _, s := range SensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(v interface{}) interface{} {
+	switch vs := v.(type) {
+	case map[string]interface{}:
+		for key, value := range vs {
+			if isSensitiveKey(key) {
+				vs[key] = RedactedValue
+			} else {
+				vs[key] = redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range vs {
+			vs[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+// RedactBody returns the request body to be kept in the audit record,
+// which redacts the values of the sensitive keys in the JSON body
+// and truncates the result to maxsize bytes if maxsize is positive.
+//
+// The body that is not a JSON is not kept, but only its size.
+func RedactBody(body []byte, maxsize int) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Sprintf("(non-JSON body of %d bytes)", len(body))
+	}
+
+	data, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return fmt.Sprintf("(invalid body of %d bytes)", len(body))
+	}
+
+	if maxsize > 0 && len(data) > maxsize {
+		return string(data[:maxsize]) + "...(truncated)"
+	}
+	return string(data)
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/xgfone/goapp/log"
+	"github.com/xgfone/klog/v4"
+)
+
+// Sink is used to write the audit records into somewhere.
+type Sink interface {
+	io.Closer
+	Write(Record) error
+}
+
+// LoggerSink returns a sink to write the audit records into the default
+// logger of goapp.
+func LoggerSink() Sink { return loggerSink{} }
+
+type loggerSink struct{}
+
+func (s loggerSink) Close() error { return nil }
+func (s loggerSink) Write(r Record) error {
+	log.Info("audit",
+		log.F("id", r.ID),
+		log.F("audit_caller", r.Caller), // "caller" is used by the logger.
+		log.F("source_ip", r.SourceIP),
+		log.F("method", r.Method),
+		log.F("path", r.Path),
+		log.F("query", r.Query),
+		log.F("body", r.Body),
+		log.F("status", r.Status),
+		log.F("result", r.Result),
+		log.F("error", r.Error))
+	return nil
+}
+
+// NewFileSink returns a sink to write the audit records into the file
+// as the JSON lines, which is rotated by the size.
+//
+// filesize is the maximum size of the file, such as "100M", and filenum
+// is the maximum number of the rotated files.
+func NewFileSink(filename, filesize string, filenum int) (Sink, error) {
+	w, err := klog.FileWriter(filename, filesize, filenum)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{writer: w}, nil
+}
+
+type fileSink struct {
+	lock   sync.Mutex
+	writer klog.Writer
+}
+
+func (s *fileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.writer.Close()
+}
+
+func (s *fileSink) Write(r Record) (err error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	_, err = s.writer.WriteLevel(klog.LvlInfo, append(data, '\n'))
+	s.lock.Unlock()
+	return
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth implements the authentication of the admin api.
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/xgfone/ship/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// callerDataKey is the key of the context data to store the caller.
+const callerDataKey = "caller"
+
+// GetCaller returns the name of the authenticated caller.
+//
+// Return "" if the request is not authenticated.
+func GetCaller(ctx *ship.Context) string {
+	caller, _ := ctx.Data[callerDataKey].(string)
+	return caller
+}
+
+// SetCaller sets the name of the authenticated caller.
+func SetCaller(ctx *ship.Context, caller string) { ctx.Data[callerDataKey] = caller }
+
+var authenticators = make(map[string]Authenticator, 4)
+
+// RegisterAuthenticator registers the authenticator, which may be enabled
+// by its name.
+//
+// If the authenticator has been registered, it will panic.
+func RegisterAuthenticator(a Authenticator) {
+	name := a.Name()
+	if _, ok := authenticators[name]; ok {
+		panic(fmt.Errorf("the authenticator named '%s' has been registered", name))
+	}
+	authenticators[name] = a
+}
+
+// UnregisterAuthenticator unregisters the authenticator by the name.
+func UnregisterAuthenticator(name string) { delete(authenticators, name) }
+
+// GetAuthenticator returns the authenticator by the name.
+//
+// Return nil if the authenticator does not exist.
+func GetAuthenticator(name string) Authenticator { return authenticators[name] }
+
+// GetAuthenticators returns the name list of all the registered authenticators.
+func GetAuthenticators() []string {
+	names := make([]string, 0, len(authenticators))
+	for name := range authenticators {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Authenticator is used to authenticate the caller of the admin api.
+type Authenticator interface {
+	// Name returns the name of the authenticator, such as "token".
+	Name() string
+
+	// Authenticate returns the name of the caller and true if the request
+	// is authenticated. Or, return ("", false).
+	Authenticate(*ship.Context) (caller string, ok bool)
+}
+
+// NewAuthenticator returns a new authenticator.
+func NewAuthenticator(name string, authenticate func(*ship.Context) (string, bool)) Authenticator {
+	return authenticator{name: name, auth: authenticate}
+}
+
+type authenticator struct {
+	name string
+	auth func(*ship.Context) (string, bool)
+}
+
+func (a authenticator) Name() string { return a.name }
+func (a authenticator) Authenticate(c *ship.Context) (string, bool) {
+	return a.auth(c)
+}
+
+// TokenAuthenticator returns an authenticator named "token" based on
+// the static api tokens, which are the map from the token to the caller.
+//
+// The token is carried by the header "Authorization: Bearer <TOKEN>".
+func TokenAuthenticator(tokens map[string]string) Authenticator {
+	return NewAuthenticator("token", func(ctx *ship.Context) (string, bool) {
+		auth := ctx.GetHeader(ship.HeaderAuthorization)
+		if len(auth) <= 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return "", false
+		}
+
+		token := []byte(strings.TrimSpace(auth[7:]))
+		for t, caller := range tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return caller, true
+			}
+		}
+		return "", false
+	})
+}
+
+// BasicAuthenticator returns an authenticator named "basic" based on
+// the HTTP basic authentication, which users is the map from the username
+// to the bcrypt hash of the password.
+func BasicAuthenticator(users map[string]string) Authenticator {
+	return NewAuthenticator("basic", func(ctx *ship.Context) (string, bool) {
+		username, password, ok := ctx.BasicAuth()
+		if !ok {
+			return "", false
+		}
+
+		hash, ok := users[username]
+		if !ok || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return "", false
+		}
+		return username, true
+	})
+}
+
+// CertAuthenticator returns an authenticator named "cert" based on
+// the TLS client certificate, which has been verified by the server,
+// and uses the common name of the certificate as the caller.
+func CertAuthenticator() Authenticator {
+	return NewAuthenticator("cert", func(ctx *ship.Context) (string, bool) {
+		state := ctx.Request().TLS
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			return "", false
+		}
+
+		cert := state.VerifiedChains[0][0]
+		if cert.Subject.CommonName == "" {
+			return cert.Subject.String(), true
+		}
+		return cert.Subject.CommonName, true
+	})
+}
+
+// Middleware returns a middleware to authenticate the request by the
+// authenticators in turn, which rejects the request with 401 if no
+// authenticator authenticates it.
+//
+// If there is no authenticator, do nothing.
+func Middleware(authenticators ...Authenticator) ship.Middleware {
+	var realm string
+	for _, a := range authenticators {
+		if a.Name() == "basic" {
+			realm = `Basic realm="apigateway"`
+		}
+	}
+
+	return func(next ship.Handler) ship.Handler {
+		if len(authenticators) == 0 {
+			return next
+		}
+
+		return func(ctx *ship.Context) error {
+			for _, a := range authenticators {
+				if caller, ok := a.Authenticate(ctx); ok {
+					SetCaller(ctx, caller)
+					return next(ctx)
+				}
+			}
+
+			if realm != "" {
+				ctx.SetHeader(ship.HeaderWWWAuthenticate, realm)
+			}
+			return ship.ErrUnauthorized
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"fmt"
+	"path"
+	"sync"
+)
+
+// Role is the role of the caller of the admin api, and the greater role
+// has all the permissions of the lesser ones.
+type Role int
+
+// Predefine some roles.
+const (
+	// RoleViewer is allowed to read the hosts, the routes and the backends.
+	RoleViewer Role = iota + 1
+
+	// RoleRouteEditor is allowed to add, update and delete the routes
+	// and their backends additionally.
+	RoleRouteEditor
+
+	// RoleHostAdmin is allowed to add and delete the hosts and the backend
+	// groups additionally.
+	RoleHostAdmin
+)
+
+var roles = map[string]Role{
+	"viewer":       RoleViewer,
+	"route-editor": RoleRouteEditor,
+	"host-admin":   RoleHostAdmin,
+}
+
+// ParseRole parses the role from the name.
+func ParseRole(name string) (Role, error) {
+	if role, ok := roles[name]; ok {
+		return role, nil
+	}
+	return 0, fmt.Errorf("unknown role '%s'", name)
+}
+
+func (r Role) String() string {
+	for name, role := range roles {
+		if role == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+type grant struct {
+	Role Role
+	Host string
+}
+
+// RBAC is the role-based access control of the admin api, which grants
+// the callers the roles scoped to the host patterns.
+//
+// The host pattern is the shell pattern, such as "*.example.com",
+// and "*" matches all the hosts including the default host "".
+type RBAC struct {
+	lock   sync.RWMutex
+	grants map[string][]grant
+}
+
+// NewRBAC returns a new RBAC.
+func NewRBAC() *RBAC { return &RBAC{grants: make(map[string][]grant, 8)} }
+
+// Enabled reports whether any role has been granted.
+//
+// If not, the access control is disabled and everything is allowed.
+func (r *RBAC) Enabled() bool {
+	r.lock.RLock()
+	enabled := len(r.grants) > 0
+	r.lock.RUnlock()
+	return enabled
+}
+
+// Grant grants the role scoped to the host patterns to the caller.
+func (r *RBAC) Grant(caller string, role Role, hostPatterns ...string) error {
+	for _, pattern := range hostPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid host pattern '%s': %v", pattern, err)
+		}
+	}
+
+	r.lock.Lock()
+	for _, pattern := range hostPatterns {
+		r.grants[caller] = append(r.grants[caller], grant{Role: role, Host: pattern})
+	}
+	r.lock.Unlock()
+	return nil
+}
+
+// Allow reports whether the caller has the role on the host.
+func (r *RBAC) Allow(caller string, role Role, host string) (ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.grants) == 0 {
+		return true
+	}
+
+	for _, g := range r.grants[caller] {
+		if g.Role >= role {
+			if matched, _ := path.Match(g.Host, host); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowAll reports whether the caller has the role on all the hosts,
+// that's, it has been granted the role with the host pattern "*".
+func (r *RBAC) AllowAll(caller string, role Role) (ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.grants) == 0 {
+		return true
+	}
+
+	for _, g := range r.grants[caller] {
+		if g.Role >= role && g.Host == "*" {
+			return true
+		}
+	}
+	return false
+}
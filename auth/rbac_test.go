@@ -0,0 +1,82 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import "testing"
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleViewer, RoleRouteEditor, RoleHostAdmin} {
+		if r, err := ParseRole(role.String()); err != nil {
+			t.Error(err)
+		} else if r != role {
+			t.Errorf("expect the role '%s', but got '%s'", role, r)
+		}
+	}
+
+	if _, err := ParseRole("unknown"); err == nil {
+		t.Errorf("expect an error for the unknown role, but got nil")
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	r := NewRBAC()
+	if r.Enabled() {
+		t.Fatal("the empty rbac is enabled")
+	} else if !r.Allow("anyone", RoleHostAdmin, "www.example.com") ||
+		!r.AllowAll("anyone", RoleHostAdmin) {
+		t.Fatal("the empty rbac does not allow everything")
+	}
+
+	if err := r.Grant("admin", RoleHostAdmin, "*"); err != nil {
+		t.Fatal(err)
+	} else if err := r.Grant("editor", RoleRouteEditor, "*.example.com", ""); err != nil {
+		t.Fatal(err)
+	} else if err := r.Grant("viewer", RoleViewer, "www.example.org"); err != nil {
+		t.Fatal(err)
+	} else if err := r.Grant("invalid", RoleViewer, "["); err == nil {
+		t.Fatal("expect an error for the invalid host pattern, but got nil")
+	}
+
+	tests := []struct {
+		caller string
+		role   Role
+		host   string
+		allow  bool
+		all    bool
+	}{
+		{"admin", RoleHostAdmin, "www.example.com", true, true},
+		{"admin", RoleViewer, "", true, true},
+		{"editor", RoleRouteEditor, "www.example.com", true, false},
+		{"editor", RoleViewer, "www.example.com", true, false},
+		{"editor", RoleRouteEditor, "", true, false},
+		{"editor", RoleHostAdmin, "www.example.com", false, false},
+		{"editor", RoleRouteEditor, "www.example.org", false, false},
+		{"viewer", RoleViewer, "www.example.org", true, false},
+		{"viewer", RoleRouteEditor, "www.example.org", false, false},
+		{"viewer", RoleViewer, "www.example.com", false, false},
+		{"unknown", RoleViewer, "www.example.com", false, false},
+	}
+
+	for _, tt := range tests {
+		if allow := r.Allow(tt.caller, tt.role, tt.host); allow != tt.allow {
+			t.Errorf("%s: expect allow '%s' on '%s' to be %v, but got %v",
+				tt.caller, tt.role, tt.host, tt.allow, allow)
+		}
+		if all := r.AllowAll(tt.caller, tt.role); all != tt.all {
+			t.Errorf("%s: expect allow '%s' on all hosts to be %v, but got %v",
+				tt.caller, tt.role, tt.all, all)
+		}
+	}
+}
@@ -15,10 +15,12 @@
 package backend
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -27,8 +29,6 @@ import (
 	"github.com/xgfone/apigw/forward/lb/backend"
 	"github.com/xgfone/go-service/loadbalancer"
 	"github.com/xgfone/go-tools/v7/lifecycle"
-	"github.com/xgfone/go-tools/v7/strings2"
-	"github.com/xgfone/ship/v3"
 )
 
 // HC is the global health checker.
@@ -39,35 +39,24 @@ var DefaultForwarderMaxTimeout time.Duration
 
 func init() {
 	HC = loadbalancer.NewHealthCheck()
-	HC.Interval = time.Second * 10
+	HC.Subscribe("", historyRecorder{})
+	HC.Subscribe("", forwarderNotifier{})
 	lifecycle.Register(HC.Stop)
 }
 
-// NewForwarder returns a new backend forwarder.
-//
-// If maxTimeout is ZERO, it is equal to DefaultForwarderMaxTimeout by default.
-func NewForwarder(name string, maxTimeout time.Duration) lb.Forwarder {
-	if maxTimeout == 0 {
-		maxTimeout = DefaultForwarderMaxTimeout
-	}
-
-	return lb.NewForwarder(name, &lb.ForwarderConfig{
-		MaxTimeout:  maxTimeout,
-		HealthCheck: HC,
-		UpdateLoadBalancer: func(lb *loadbalancer.LoadBalancer) {
-			lb.Session = loadbalancer.NewMemorySessionManager()
-		},
-	})
-}
-
-// IsHealthy reports whether the backend is healthy.
+// IsHealthy reports whether the backend is healthy, which respects
+// its administrative state. See IsEndpointOnline.
 func IsHealthy(backend lb.Backend) bool {
-	return HC.IsHealthy(backend.String())
+	return IsEndpointOnline(backend.String())
 }
 
 // Backend is the backend of the route.
+//
+// Weight is the weight of the backend used by the weighted load-balancing
+// policies, which is 1 if ZERO.
 type Backend struct {
 	Type     string                 `json:"type" validate:"required"`
+	Weight   int                    `json:"weight,omitempty" validate:"min=0"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 	Timeout  string                 `json:"timeout,omitempty"`
 	Interval string                 `json:"interval,omitempty"`
@@ -75,35 +64,263 @@ type Backend struct {
 }
 
 // Backend converts the information to the lb backend.
-func (b Backend) Backend(r apigw.Route) (_ lb.Backend, err error) {
-	hc := lb.HealthCheck{RetryNum: b.RetryNum}
+func (b Backend) Backend(r apigw.Route) (lb.Backend, error) { return b.backend(r, nil) }
+
+// inheritTransport reports whether the backend inherits the transport
+// of the backend group, that's, it is a http backend without its own.
+func (b Backend) inheritTransport() bool {
+	return b.Type == "http" && b.Metadata["transport"] == nil
+}
+
+// Validate checks whether the backend is valid without building it,
+// so it has no side effect, such as creating the http transport.
+//
+// For the backend group, it does not check whether the group exists.
+// For the types except "http" and "group", only check whether the builder
+// has been registered.
+func (b Backend) Validate() (err error) {
+	if b.Weight < 0 {
+		return fmt.Errorf("invalid weight '%d'", b.Weight)
+	} else if _, err = b.healthCheck(); err != nil {
+		return
+	} else if _, err = b.circuitBreaker(); err != nil {
+		return
+	}
+
+	switch b.Type {
+	case "group":
+		if name, _ := b.Metadata["name"].(string); name == "" {
+			return errors.New("missing the group name")
+		}
+
+	case "http":
+		spec, err := parseHTTPBackend(b.Metadata)
+		if err != nil {
+			return err
+		} else if !spec.TLS.IsZero() {
+			if _, err = spec.TLS.TLSConfig(spec.host); err != nil {
+				return fmt.Errorf("invalid tls: %s", err)
+			}
+		}
+
+	default:
+		if backend.GetBuilder(b.Type) == nil {
+			return fmt.Errorf("no the backend typed '%s'", b.Type)
+		}
+	}
+
+	return nil
+}
+
+// Addr returns the address of the backend without building it, which is
+// the same as the method String of the built backend, such as the url of
+// the http backend and the name of the backend group.
+//
+// For the types except "http" and "group", the backend has to be built.
+func (b Backend) Addr(r apigw.Route) (addr string, err error) {
+	switch b.Type {
+	case "group":
+		if addr, _ = b.Metadata["name"].(string); addr == "" {
+			err = errors.New("missing the group name")
+		}
+		return
+
+	case "http":
+		rawurl, _ := b.Metadata["url"].(string)
+		if rawurl == "" {
+			return "", errors.New("missing the url")
+		}
+
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return "", err
+		} else if u.Path == "" {
+			u.Path = "/"
+		}
+		return u.String(), nil
+
+	default:
+		backend, err := b.Backend(r)
+		if err != nil {
+			return "", err
+		}
+		return backend.String(), nil
+	}
+}
+
+func (b Backend) healthCheck() (hc lb.HealthCheck, err error) {
+	hc.RetryNum = b.RetryNum
 	if b.Interval != "" {
 		if hc.Interval, err = time.ParseDuration(b.Interval); err != nil {
-			return nil, err
+			return
 		}
 	}
 	if b.Timeout != "" {
 		if hc.Timeout, err = time.ParseDuration(b.Timeout); err != nil {
-			return nil, err
+			return
+		}
+	}
+	return
+}
+
+// backend converts the information to the lb backend, which uses
+// the transport if it inherits the transport and transport is not nil.
+func (b Backend) backend(r apigw.Route, transport *TransportConfig) (_ lb.Backend, err error) {
+	if b.Weight < 0 {
+		return nil, fmt.Errorf("invalid weight '%d'", b.Weight)
+	}
+
+	hc, err := b.healthCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	cb, err := b.circuitBreaker()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := b.Metadata
+	if transport != nil && b.inheritTransport() {
+		metadata = make(map[string]interface{}, len(b.Metadata)+1)
+		for key, value := range b.Metadata {
+			metadata[key] = value
 		}
+		metadata["transport"] = *transport
+	} else {
+		transport = nil
 	}
 
 	if builder := backend.GetBuilder(b.Type); builder != nil {
 		backend, err := builder.New(backend.BuilderContext{
 			Route:       r,
-			MetaData:    b.Metadata,
+			MetaData:    metadata,
 			HealthCheck: hc,
 		})
 		if err != nil {
 			return nil, err
+		} else if cb != nil {
+			// The configuration has been validated, so it does not fail.
+			if backend, err = NewCircuitBreakerBackend(*cb, backend); err != nil {
+				return nil, err
+			}
 		}
 
-		return lb.NewBackendWithHealthCheck(backend, hc), nil
+		weight := int32(b.Weight)
+		return configBackend{
+			Backend:   lb.NewBackendWithHealthCheck(backend, hc),
+			inner:     backend,
+			conf:      b,
+			route:     r,
+			transport: transport,
+			weight:    &weight,
+		}, nil
 	}
 
 	return nil, fmt.Errorf("no the backend typed '%s'", b.Type)
 }
 
+// circuitBreaker returns the configuration of the circuit breaker
+// by the metadata "circuitbreaker", which is nil if not configured.
+func (b Backend) circuitBreaker() (*CircuitBreakerConfig, error) {
+	var md struct {
+		CircuitBreaker *CircuitBreakerConfig `mapstructure:"circuitbreaker"`
+	}
+	if err := decodeMetadata(b.Metadata, &md); err != nil {
+		return nil, err
+	} else if md.CircuitBreaker == nil {
+		return nil, nil
+	} else if b.Type == "group" {
+		return nil, errors.New("the backend group does not support the circuit breaker")
+	} else if err := md.CircuitBreaker.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid circuitbreaker: %s", err)
+	}
+	return md.CircuitBreaker, nil
+}
+
+// NewBackend converts the lb backend to Backend.
+//
+// If the lb backend is built by the method Backend, return the original
+// configuration. Or, build it from the type, metadata and health check.
+func NewBackend(b lb.Backend) Backend {
+	if cb, ok := getConfigBackend(b); ok {
+		conf := cb.conf
+		conf.Weight = int(atomic.LoadInt32(cb.weight))
+		return conf
+	}
+
+	var interval, timeout string
+	hc := b.HealthCheck()
+	if hc.Interval > 0 {
+		interval = hc.Interval.String()
+	}
+	if hc.Timeout > 0 {
+		timeout = hc.Timeout.String()
+	}
+
+	return Backend{
+		Type:     b.Type(),
+		Metadata: b.MetaData(),
+		RetryNum: hc.RetryNum,
+		Interval: interval,
+		Timeout:  timeout,
+	}
+}
+
+// UpdateBackend updates the backend old by new in place, which only supports
+// to update the weight, and reports whether old is equal to new after that.
+func UpdateBackend(old, new lb.Backend) bool {
+	ocb, ok1 := getConfigBackend(old)
+	ncb, ok2 := getConfigBackend(new)
+	if !ok1 || !ok2 {
+		return reflect.DeepEqual(NewBackend(old), NewBackend(new))
+	}
+
+	oconf, nconf := ocb.conf, ncb.conf
+	oconf.Weight, nconf.Weight = 0, 0
+	if !reflect.DeepEqual(oconf, nconf) || !reflect.DeepEqual(ocb.transport, ncb.transport) {
+		return false
+	}
+
+	atomic.StoreInt32(ocb.weight, atomic.LoadInt32(ncb.weight))
+	return true
+}
+
+// configBackend is the backend built from Backend, which keeps the original
+// configuration in order to export it again.
+type configBackend struct {
+	lb.Backend
+	inner     lb.Backend
+	conf      Backend
+	route     apigw.Route
+	transport *TransportConfig // The transport inherited from the backend group.
+	weight    *int32           // The configured weight, which may be updated in place.
+}
+
+func getConfigBackend(b lb.Backend) (configBackend, bool) {
+	for ep := loadbalancer.Endpoint(b); ep != nil; {
+		if cb, ok := ep.(configBackend); ok {
+			return cb, true
+		} else if eu, ok := ep.(loadbalancer.EndpointUnwrap); ok {
+			ep = eu.Unwrap()
+		} else {
+			break
+		}
+	}
+	return configBackend{}, false
+}
+
+func (b configBackend) Unwrap() loadbalancer.Endpoint { return b.Backend }
+func (b configBackend) UnwrapBackend() lb.Backend     { return b.inner }
+
+// Weight implements the interface loadbalancer.WeightEndpoint.
+func (b configBackend) Weight() int {
+	if weight := atomic.LoadInt32(b.weight); weight > 0 {
+		return int(weight)
+	}
+	return 1
+}
+
 // Backends is a set of Backends.
 type Backends []Backend
 
@@ -119,6 +336,30 @@ func (bs Backends) Backends(r apigw.Route) ([]lb.Backend, error) {
 	return backends, err
 }
 
+// Addrs returns the addresses of the backends. See Backend.Addr.
+func (bs Backends) Addrs(r apigw.Route) (addrs []string, err error) {
+	addrs = make([]string, len(bs))
+	for i, _len := 0, len(bs); i < _len; i++ {
+		if addrs[i], err = bs[i].Addr(r); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GroupBackends is the same as Backends, but converts themself to the backends
+// of the backend group, which inherit the transport of the group.
+func (bs Backends) GroupBackends(r apigw.Route, group GroupConfig) ([]lb.Backend, error) {
+	var err error
+	backends := make([]lb.Backend, len(bs))
+	for i, _len := 0, len(bs); i < _len; i++ {
+		if backends[i], err = bs[i].backend(r, group.Transport); err != nil {
+			return backends, err
+		}
+	}
+	return backends, err
+}
+
 func init() {
 	backend.RegisterBuilder(backend.NewBuilder("group", func(c backend.BuilderContext) (lb.Backend, error) {
 		name, ok := c.MetaData["name"].(string)
@@ -138,47 +379,110 @@ func init() {
 	}))
 
 	backend.RegisterBuilder(backend.NewBuilder("http", func(c backend.BuilderContext) (lb.Backend, error) {
-		var req struct {
-			QPS      int    `mapstructure:"qps"`
-			URL      string `mapstructure:"url"`
-			Method   string `mapstructure:"method"`
-			CheckURL string `mapstructure:"checkurl"`
+		spec, err := parseHTTPBackend(c.MetaData)
+		if err != nil {
+			return nil, err
 		}
-		if err := mapstructure.Decode(c.MetaData, &req); err != nil {
+
+		client, err := getHTTPClient(spec.opts, spec.TLS, spec.host)
+		if err != nil {
 			return nil, err
-		} else if req.URL == "" {
-			return nil, fmt.Errorf("missing the url")
-		}
-
-		var hc loadbalancer.HealthChecker
-		if req.CheckURL != "" {
-			hc = func(c context.Context, url string) error {
-				resp, err := http.Get(req.CheckURL)
-				if err != nil {
-					return err
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode == 200 {
-					return nil
-				}
-
-				buf := strings2.NewBuilder(int(resp.ContentLength))
-				ship.CopyNBuffer(buf, resp.Body, resp.ContentLength, nil)
-				return errors.New(buf.String())
-			}
 		}
 
 		conf := &backend.HTTPBackendConfig{
+			Client:        client.Client,
 			UserData:      c.UserData,
 			HealthCheck:   c.HealthCheck,
-			HealthChecker: hc,
+			HealthChecker: spec.checker,
 		}
-		next, err := backend.NewHTTPBackend(req.Method, req.URL, conf)
+		next, err := backend.NewHTTPBackend(spec.Method, spec.URL, conf)
 		if err != nil {
 			return nil, err
 		}
 
-		return backend.NewQPSBackend(req.QPS, next), nil
+		return clientBackend{backend.NewQPSBackend(spec.QPS, next), client}, nil
 	}))
 }
+
+// httpBackendSpec is the metadata of the http backend.
+type httpBackendSpec struct {
+	QPS         int                    `mapstructure:"qps"`
+	URL         string                 `mapstructure:"url"`
+	Method      string                 `mapstructure:"method"`
+	CheckURL    string                 `mapstructure:"checkurl"` // Deprecated
+	HealthCheck map[string]interface{} `mapstructure:"healthcheck"`
+	TLS         TLSConfig              `mapstructure:"tls"`
+	Transport   TransportConfig        `mapstructure:"transport"`
+
+	host    string // The host to verify the certificate.
+	opts    TransportOptions
+	checker loadbalancer.HealthChecker
+}
+
+// parseHTTPBackend parses and validates the metadata of the http backend,
+// but does not create the http transport.
+func parseHTTPBackend(metadata map[string]interface{}) (spec httpBackendSpec, err error) {
+	if err = decodeMetadata(metadata, &spec); err != nil {
+		return
+	} else if spec.URL == "" {
+		return spec, fmt.Errorf("missing the url")
+	}
+
+	// checkurl is equal to the http health check only with the url,
+	// which expects the status code 200 as before.
+	if spec.HealthCheck == nil && spec.CheckURL != "" {
+		spec.HealthCheck = map[string]interface{}{
+			"type":   "http",
+			"path":   spec.CheckURL,
+			"status": []string{"200"},
+		}
+	}
+
+	if !spec.TLS.IsZero() {
+		if !strings.HasPrefix(spec.URL, "https://") {
+			return spec, fmt.Errorf("the tls options require the https url")
+		}
+
+		u, err := url.Parse(spec.URL)
+		if err != nil {
+			return spec, fmt.Errorf("invalid url: %s", err)
+		}
+		spec.host = u.Hostname()
+
+		// The http health check uses the tls options of the backend by default.
+		if _type, _ := spec.HealthCheck["type"].(string); spec.HealthCheck != nil &&
+			(_type == "" || _type == "http") && spec.HealthCheck["tls"] == nil {
+			hc := make(map[string]interface{}, len(spec.HealthCheck)+1)
+			for key, value := range spec.HealthCheck {
+				hc[key] = value
+			}
+			hc["tls"] = metadata["tls"]
+			spec.HealthCheck = hc
+		}
+	}
+
+	if spec.opts, err = spec.Transport.TransportOptions(DefaultTransportOptions); err != nil {
+		return spec, fmt.Errorf("invalid transport: %s", err)
+	}
+
+	if spec.HealthCheck != nil {
+		if spec.checker, err = NewHealthChecker(spec.HealthCheck); err != nil {
+			return spec, fmt.Errorf("invalid healthcheck: %s", err)
+		}
+	}
+
+	return
+}
+
+// decodeMetadata decodes the metadata of the backend into the struct v,
+// which converts the weakly typed values, such as the number to the string.
+func decodeMetadata(metadata map[string]interface{}, v interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           v,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(metadata)
+}
@@ -0,0 +1,357 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/go-service/loadbalancer"
+	"github.com/xgfone/ship/v3"
+)
+
+// ErrCircuitOpen is returned when the circuit breaker of the backend is open.
+var ErrCircuitOpen = errors.New("the circuit breaker is open")
+
+// Define the states of the circuit breaker.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// Define the default settings of the circuit breaker.
+const (
+	DefaultCircuitFailureRatio     = 0.5
+	DefaultCircuitMinRequests      = 10
+	DefaultCircuitWindow           = time.Second * 10
+	DefaultCircuitOpenTimeout      = time.Second * 30
+	DefaultCircuitHalfOpenRequests = 1
+)
+
+// CircuitBreakerConfig is the configuration of the circuit breaker of the
+// backend, which is configured by the metadata "circuitbreaker" of the backend.
+//
+// The circuit breaker is closed at first. In every Window, if there are
+// MinRequests requests at least and the ratio of the failed requests reaches
+// FailureRatio or the ratio of the slow requests reaches SlowRatio, it is open,
+// and all the requests fail fast or fail over to other backends. After
+// OpenTimeout, it is half-open and allows HalfOpenRequests trial requests.
+// If all of them succeed, it is closed again. Or, it is open again.
+//
+// The failed request is the one with the connection error or 5xx response.
+type CircuitBreakerConfig struct {
+	// FailureRatio is DefaultCircuitFailureRatio by default.
+	FailureRatio float64 `mapstructure:"failure_ratio" json:"failure_ratio,omitempty"`
+
+	// MinRequests is DefaultCircuitMinRequests by default.
+	MinRequests int `mapstructure:"min_requests" json:"min_requests,omitempty"`
+
+	// Window is DefaultCircuitWindow by default.
+	Window string `mapstructure:"window" json:"window,omitempty"`
+
+	// SlowThreshold is the latency of the slow request, and the slow requests
+	// are not counted if it is empty.
+	SlowThreshold string `mapstructure:"slow_threshold" json:"slow_threshold,omitempty"`
+
+	// SlowRatio is FailureRatio by default.
+	SlowRatio float64 `mapstructure:"slow_ratio" json:"slow_ratio,omitempty"`
+
+	// OpenTimeout is DefaultCircuitOpenTimeout by default.
+	OpenTimeout string `mapstructure:"open_timeout" json:"open_timeout,omitempty"`
+
+	// HalfOpenRequests is DefaultCircuitHalfOpenRequests by default.
+	HalfOpenRequests int `mapstructure:"half_open_requests" json:"half_open_requests,omitempty"`
+}
+
+// CircuitBreakerState is the state of the circuit breaker of the backend.
+type CircuitBreakerState struct {
+	Forwarder string     `json:"forwarder,omitempty"`
+	State     string     `json:"state"`
+	Requests  int        `json:"requests"`
+	Failures  int        `json:"failures"`
+	Slows     int        `json:"slows"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+}
+
+// Validate validates whether the configuration is valid.
+func (c CircuitBreakerConfig) Validate() error {
+	_, err := NewCircuitBreakerBackend(c, nil)
+	return err
+}
+
+// NewCircuitBreakerBackend returns a new backend with the circuit breaker,
+// which wraps the backend next.
+func NewCircuitBreakerBackend(conf CircuitBreakerConfig, next lb.Backend) (lb.Backend, error) {
+	b := &circuitBreakerBackend{
+		Backend:          next,
+		failureRatio:     conf.FailureRatio,
+		slowRatio:        conf.SlowRatio,
+		minRequests:      conf.MinRequests,
+		halfOpenRequests: conf.HalfOpenRequests,
+		window:           DefaultCircuitWindow,
+		openTimeout:      DefaultCircuitOpenTimeout,
+		state:            CircuitClosed,
+		start:            time.Now(),
+	}
+
+	if b.failureRatio < 0 || b.failureRatio > 1 {
+		return nil, fmt.Errorf("invalid failure ratio '%v'", b.failureRatio)
+	} else if b.slowRatio < 0 || b.slowRatio > 1 {
+		return nil, fmt.Errorf("invalid slow ratio '%v'", b.slowRatio)
+	} else if b.minRequests < 0 || b.halfOpenRequests < 0 {
+		return nil, errors.New("the number of the requests must not be negative")
+	}
+
+	if b.failureRatio == 0 {
+		b.failureRatio = DefaultCircuitFailureRatio
+	}
+	if b.slowRatio == 0 {
+		b.slowRatio = b.failureRatio
+	}
+	if b.minRequests == 0 {
+		b.minRequests = DefaultCircuitMinRequests
+	}
+	if b.halfOpenRequests == 0 {
+		b.halfOpenRequests = DefaultCircuitHalfOpenRequests
+	}
+
+	var err error
+	if conf.Window != "" {
+		if b.window, err = time.ParseDuration(conf.Window); err != nil {
+			return nil, err
+		}
+	}
+	if conf.OpenTimeout != "" {
+		if b.openTimeout, err = time.ParseDuration(conf.OpenTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if conf.SlowThreshold != "" {
+		if b.slowThreshold, err = time.ParseDuration(conf.SlowThreshold); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+type circuitBreakerBackend struct {
+	lb.Backend
+
+	failureRatio     float64
+	slowRatio        float64
+	slowThreshold    time.Duration
+	minRequests      int
+	halfOpenRequests int
+	window           time.Duration
+	openTimeout      time.Duration
+
+	lock       sync.Mutex
+	state      string
+	generation uint64    // Increase when the state is changed.
+	start      time.Time // The start of the window or the time when it is open.
+	requests   int
+	failures   int
+	slows      int
+	inflight   int // The trial requests in the half-open state.
+}
+
+func (b *circuitBreakerBackend) UnwrapBackend() lb.Backend { return b.Backend }
+
+func (b *circuitBreakerBackend) MetaData() map[string]interface{} {
+	md := b.Backend.MetaData()
+	md["circuit_state"] = b.State().State
+	return md
+}
+
+// State returns the state of the circuit breaker.
+func (b *circuitBreakerBackend) State() (s CircuitBreakerState) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.updateState(time.Now())
+	s = CircuitBreakerState{
+		State:    b.state,
+		Requests: b.requests,
+		Failures: b.failures,
+		Slows:    b.slows,
+	}
+	if b.state != CircuitClosed {
+		start := b.start
+		s.OpenedAt = &start
+	}
+	return
+}
+
+// Available reports whether the backend is available for the new request.
+func (b *circuitBreakerBackend) Available() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.updateState(time.Now())
+	switch b.state {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		return b.inflight < b.halfOpenRequests
+	default:
+		return false
+	}
+}
+
+func (b *circuitBreakerBackend) setState(state string, now time.Time) {
+	b.state = state
+	b.generation++
+	b.start = now
+	b.requests, b.failures, b.slows, b.inflight = 0, 0, 0, 0
+}
+
+// updateState moves to the next window or the half-open state by the time.
+func (b *circuitBreakerBackend) updateState(now time.Time) {
+	switch b.state {
+	case CircuitClosed:
+		if now.Sub(b.start) >= b.window {
+			b.setState(CircuitClosed, now)
+		}
+	case CircuitOpen:
+		if now.Sub(b.start) >= b.openTimeout {
+			b.setState(CircuitHalfOpen, now)
+		}
+	}
+}
+
+func (b *circuitBreakerBackend) acquire() (generation uint64, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.updateState(time.Now())
+	switch b.state {
+	case CircuitClosed:
+		ok = true
+	case CircuitHalfOpen:
+		if ok = b.inflight < b.halfOpenRequests; ok {
+			b.inflight++
+		}
+	}
+	return b.generation, ok
+}
+
+func (b *circuitBreakerBackend) release(generation uint64, failed bool, latency time.Duration) {
+	slow := b.slowThreshold > 0 && latency >= b.slowThreshold
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// The state has been changed, so ignore the result of the old request.
+	if generation != b.generation {
+		return
+	}
+
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slows++
+	}
+
+	now := time.Now()
+	switch b.state {
+	case CircuitClosed:
+		if b.requests >= b.minRequests {
+			total := float64(b.requests)
+			if float64(b.failures)/total >= b.failureRatio ||
+				(b.slowThreshold > 0 && float64(b.slows)/total >= b.slowRatio) {
+				b.setState(CircuitOpen, now)
+			}
+		}
+	case CircuitHalfOpen:
+		if failed || slow {
+			b.setState(CircuitOpen, now)
+		} else if b.requests-b.failures >= b.halfOpenRequests {
+			b.setState(CircuitClosed, now)
+		}
+	}
+}
+
+func (b *circuitBreakerBackend) RoundTrip(c context.Context, r lb.Request) (lb.Response, error) {
+	generation, ok := b.acquire()
+	if !ok {
+		return nil, ship.ErrServiceUnavailable.New(ErrCircuitOpen)
+	}
+
+	start := time.Now()
+	resp, err := b.Backend.RoundTrip(c, r)
+
+	failed := err != nil
+	if req, ok := r.(interface{ Context() *apigw.Context }); ok {
+		failed, _ = isForwardFailure(req.Context(), err)
+	}
+	b.release(generation, failed, time.Since(start))
+	return resp, err
+}
+
+func getCircuitBreaker(ep loadbalancer.Endpoint) *circuitBreakerBackend {
+	for ep != nil {
+		if b, ok := ep.(*circuitBreakerBackend); ok {
+			return b
+		} else if eu, ok := ep.(loadbalancer.EndpointUnwrap); ok {
+			ep = eu.Unwrap()
+		} else if bu, ok := ep.(lb.BackendUnwrap); ok {
+			ep = bu.UnwrapBackend()
+		} else {
+			break
+		}
+	}
+	return nil
+}
+
+// isEndpointAvailable reports whether the circuit breaker of the endpoint
+// allows the new request, which is true if the endpoint has no breaker.
+func isEndpointAvailable(ep loadbalancer.Endpoint) bool {
+	if b := getCircuitBreaker(ep); b != nil {
+		return b.Available()
+	}
+	return true
+}
+
+// GetCircuitBreakerStates returns the states of the circuit breakers
+// of the endpoint in the forwarders by the address.
+func GetCircuitBreakerStates(addr string) (states []CircuitBreakerState) {
+	for _, f := range getForwarders(addr) {
+		f.lock.RLock()
+		var b lb.Backend
+		if leaf, ok := f.leaves[addr]; ok {
+			b = leaf.backend
+		}
+		f.lock.RUnlock()
+
+		if cb := getCircuitBreaker(b); cb != nil {
+			state := cb.State()
+			state.Forwarder = f.name
+			states = append(states, state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Forwarder < states[j].Forwarder })
+	return
+}
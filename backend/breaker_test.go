@@ -0,0 +1,154 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf CircuitBreakerConfig
+		fail bool
+	}{
+		{"default", CircuitBreakerConfig{}, false},
+		{"valid", CircuitBreakerConfig{FailureRatio: 1, Window: "1s", SlowThreshold: "100ms"}, false},
+		{"invalid failure ratio", CircuitBreakerConfig{FailureRatio: 1.5}, true},
+		{"invalid slow ratio", CircuitBreakerConfig{SlowRatio: -0.1}, true},
+		{"negative requests", CircuitBreakerConfig{MinRequests: -1}, true},
+		{"invalid window", CircuitBreakerConfig{Window: "abc"}, true},
+		{"invalid open timeout", CircuitBreakerConfig{OpenTimeout: "abc"}, true},
+		{"invalid slow threshold", CircuitBreakerConfig{SlowThreshold: "abc"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.conf.Validate(); tt.fail && err == nil {
+			t.Errorf("%s: expect an error, but got nil", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	conf := CircuitBreakerConfig{
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		Window:           "10s",
+		SlowThreshold:    "100ms",
+		OpenTimeout:      "30s",
+		HalfOpenRequests: 2,
+	}
+	backend, err := NewCircuitBreakerBackend(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backend.(*circuitBreakerBackend)
+
+	steps := []struct {
+		op    string
+		state string
+	}{
+		{"ok", CircuitClosed},
+		{"fail", CircuitClosed},
+		{"ok", CircuitClosed},
+		{"fail", CircuitOpen}, // 2 of 4 requests failed
+		{"reject", CircuitOpen},
+		{"wait_open", CircuitHalfOpen},
+		{"ok", CircuitHalfOpen},
+		{"fail", CircuitOpen}, // The trial request failed
+		{"wait_open", CircuitHalfOpen},
+		{"ok", CircuitHalfOpen},
+		{"ok", CircuitClosed}, // All the trial requests succeeded
+		{"fail", CircuitClosed},
+		{"fail", CircuitClosed},
+		{"fail", CircuitClosed},
+		{"wait_window", CircuitClosed}, // The failures are reset
+		{"fail", CircuitClosed},
+		{"slow", CircuitClosed},
+		{"slow", CircuitClosed},
+		{"ok", CircuitOpen}, // 2 of 4 requests were slow
+	}
+
+	for i, step := range steps {
+		switch step.op {
+		case "ok", "fail", "slow":
+			generation, ok := b.acquire()
+			if !ok {
+				t.Fatalf("%d: the request is rejected unexpectedly", i)
+			}
+
+			var latency time.Duration
+			if step.op == "slow" {
+				latency = time.Second
+			}
+			b.release(generation, step.op == "fail", latency)
+
+		case "reject":
+			if b.Available() {
+				t.Errorf("%d: expect the request to be rejected", i)
+			}
+
+		case "wait_window":
+			b.lock.Lock()
+			b.start = b.start.Add(-b.window)
+			b.lock.Unlock()
+
+		case "wait_open":
+			b.lock.Lock()
+			b.start = b.start.Add(-b.openTimeout)
+			b.lock.Unlock()
+		}
+
+		if state := b.State().State; state != step.state {
+			t.Fatalf("%d: expect the state '%s' after '%s', but got '%s'",
+				i, step.state, step.op, state)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenRequests(t *testing.T) {
+	backend, err := NewCircuitBreakerBackend(CircuitBreakerConfig{HalfOpenRequests: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backend.(*circuitBreakerBackend)
+
+	b.lock.Lock()
+	oldGeneration := b.generation
+	b.setState(CircuitHalfOpen, time.Now())
+	b.lock.Unlock()
+
+	// The result of the request before the state changed is ignored.
+	b.release(oldGeneration, true, 0)
+	if state := b.State().State; state != CircuitHalfOpen {
+		t.Fatalf("expect the state '%s', but got '%s'", CircuitHalfOpen, state)
+	}
+
+	// Only the limited trial requests are allowed in the half-open state.
+	generation, ok := b.acquire()
+	if !ok {
+		t.Fatal("the first trial request is rejected")
+	} else if _, ok = b.acquire(); ok {
+		t.Fatal("the second trial request is allowed")
+	}
+
+	b.release(generation, false, 0)
+	if state := b.State().State; state != CircuitClosed {
+		t.Errorf("expect the state '%s', but got '%s'", CircuitClosed, state)
+	}
+}
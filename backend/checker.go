@@ -0,0 +1,307 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// DefaultHealthCheckerType is the default type of the health checker.
+const DefaultHealthCheckerType = "http"
+
+// HealthCheckerBuilder is used to build the health checker by the
+// specification, which is the metadata "healthcheck" of the backend.
+//
+// The health checker is called with the address or url of the backend,
+// and the context with the timeout of the health check.
+type HealthCheckerBuilder func(spec map[string]interface{}) (loadbalancer.HealthChecker, error)
+
+var checkers = make(map[string]HealthCheckerBuilder, 8)
+
+func init() {
+	RegisterHealthChecker("http", func(spec map[string]interface{}) (loadbalancer.HealthChecker, error) {
+		var c HTTPHealthCheck
+		if err := decodeMetadata(spec, &c); err != nil {
+			return nil, err
+		}
+		return NewHTTPHealthChecker(c)
+	})
+
+	RegisterHealthChecker("tcp", func(spec map[string]interface{}) (loadbalancer.HealthChecker, error) {
+		var c TCPHealthCheck
+		if err := decodeMetadata(spec, &c); err != nil {
+			return nil, err
+		}
+		return NewTCPHealthChecker(c)
+	})
+
+	RegisterHealthChecker("tcp_send", func(spec map[string]interface{}) (loadbalancer.HealthChecker, error) {
+		var c TCPHealthCheck
+		if err := decodeMetadata(spec, &c); err != nil {
+			return nil, err
+		} else if c.Send == "" && c.SendHex == "" {
+			return nil, errors.New("missing the data to be sent")
+		}
+		return NewTCPHealthChecker(c)
+	})
+
+	RegisterHealthChecker("exec", func(spec map[string]interface{}) (loadbalancer.HealthChecker, error) {
+		var c ExecHealthCheck
+		if err := decodeMetadata(spec, &c); err != nil {
+			return nil, err
+		}
+		return NewExecHealthChecker(c)
+	})
+}
+
+// RegisterHealthChecker registers the builder of the health checker
+// as the type _type, which may be called by the third-party DLLs.
+//
+// If the type has been registered, it will panic.
+func RegisterHealthChecker(_type string, build HealthCheckerBuilder) {
+	if _type == "" {
+		panic("the health checker type must not be empty")
+	} else if build == nil {
+		panic("the health checker builder must not be nil")
+	} else if _, ok := checkers[_type]; ok {
+		panic(fmt.Errorf("the health checker typed '%s' has been registered", _type))
+	}
+	checkers[_type] = build
+}
+
+// UnregisterHealthChecker unregisters the health checker by the type.
+func UnregisterHealthChecker(_type string) { delete(checkers, _type) }
+
+// GetHealthCheckerTypes returns the types of all the registered health checkers.
+func GetHealthCheckerTypes() []string {
+	types := make([]string, 0, len(checkers))
+	for _type := range checkers {
+		types = append(types, _type)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewHealthChecker returns a new health checker by the specification,
+// whose key "type" is the type of the health checker, which is
+// DefaultHealthCheckerType by default.
+func NewHealthChecker(spec map[string]interface{}) (loadbalancer.HealthChecker, error) {
+	_type := DefaultHealthCheckerType
+	if v, ok := spec["type"]; ok {
+		if s, ok := v.(string); !ok {
+			return nil, fmt.Errorf("invalid health checker type '%v'", v)
+		} else if s != "" {
+			_type = s
+		}
+	}
+
+	build, ok := checkers[_type]
+	if !ok {
+		return nil, fmt.Errorf("no the health checker typed '%s'", _type)
+	}
+
+	checker, err := build(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pass the error to the health history of the endpoint.
+	return func(ctx context.Context, addrOrURL string) (err error) {
+		err = checker(ctx, addrOrURL)
+		setProbeError(ctx, err)
+		return
+	}, nil
+}
+
+// getHostPort returns the address "host:port" from the address or url.
+func getHostPort(addrOrURL string) (string, error) {
+	if !strings.Contains(addrOrURL, "://") {
+		return addrOrURL, nil
+	}
+
+	u, err := url.Parse(addrOrURL)
+	if err != nil {
+		return "", err
+	} else if u.Port() != "" {
+		return u.Host, nil
+	}
+
+	switch u.Scheme {
+	case "http":
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	default:
+		return "", fmt.Errorf("missing the port in '%s'", addrOrURL)
+	}
+}
+
+// TCPHealthCheck is the specification of the TCP health check, whose type is
+// "tcp" to only connect to the backend, or "tcp_send" to send the data and
+// expect the response additionally.
+type TCPHealthCheck struct {
+	// Address is the address "host:port" to be checked, which is the address
+	// of the backend by default.
+	Address string `mapstructure:"address" json:"address,omitempty"`
+
+	// Send or SendHex is the data sent to the backend after connecting.
+	Send    string `mapstructure:"send" json:"send,omitempty"`
+	SendHex string `mapstructure:"send_hex" json:"send_hex,omitempty"`
+
+	// Expect or ExpectHex is the data that the response must contain.
+	// If empty, the backend is healthy after the data is sent.
+	Expect    string `mapstructure:"expect" json:"expect,omitempty"`
+	ExpectHex string `mapstructure:"expect_hex" json:"expect_hex,omitempty"`
+}
+
+// NewTCPHealthChecker returns a new TCP health checker by the specification.
+func NewTCPHealthChecker(c TCPHealthCheck) (loadbalancer.HealthChecker, error) {
+	send, err := decodeHexOr(c.Send, c.SendHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid send_hex: %s", err)
+	}
+
+	expect, err := decodeHexOr(c.Expect, c.ExpectHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expect_hex: %s", err)
+	} else if len(expect) > 0 && len(send) == 0 {
+		return nil, errors.New("missing the data to be sent")
+	}
+
+	return func(ctx context.Context, addrOrURL string) (err error) {
+		addr := c.Address
+		if addr == "" {
+			if addr, err = getHostPort(addrOrURL); err != nil {
+				return
+			}
+		}
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil || len(send) == 0 {
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		defer conn.Close()
+
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		if _, err = conn.Write(send); err != nil || len(expect) == 0 {
+			return
+		}
+
+		var buf []byte
+		tmp := make([]byte, 1024)
+		for len(buf) < maxHealthCheckBodySize {
+			n, err := conn.Read(tmp)
+			buf = append(buf, tmp[:n]...)
+			if bytes.Contains(buf, expect) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("unexpected response: %v", err)
+			}
+		}
+		return errors.New("unexpected response")
+	}, nil
+}
+
+func decodeHexOr(s, hexs string) ([]byte, error) {
+	if hexs != "" {
+		return hex.DecodeString(hexs)
+	}
+	return []byte(s), nil
+}
+
+// ExecHealthCheckCommands is the allowlist of the absolute paths of the
+// commands which may be executed by the exec health checker.
+// If empty, the exec health checker is disabled.
+//
+// Notice: only the command is allowlisted, but not its arguments, so any
+// caller who may update the backends by the admin api can run the allowlisted
+// command with any arguments. So only allowlist the dedicated scripts which
+// are safe to run with any arguments, but never the shells or interpreters.
+var ExecHealthCheckCommands []string
+
+// ExecHealthCheck is the specification of the health check by executing
+// the local command, whose type is "exec". The backend is healthy only if
+// the command exits with the code 0. The command must be in the allowlist
+// ExecHealthCheckCommands, but the arguments are not limited.
+//
+// The command is killed when the health check times out. And the address
+// or url of the backend is passed to it by the environment variables,
+// "HEALTHCHECK_ADDR", "HEALTHCHECK_HOST" and "HEALTHCHECK_PORT".
+type ExecHealthCheck struct {
+	Command string   `mapstructure:"command" json:"command"`
+	Args    []string `mapstructure:"args" json:"args,omitempty"`
+}
+
+// NewExecHealthChecker returns a new health checker to execute the command.
+func NewExecHealthChecker(c ExecHealthCheck) (loadbalancer.HealthChecker, error) {
+	if c.Command == "" {
+		return nil, errors.New("missing the command")
+	} else if len(ExecHealthCheckCommands) == 0 {
+		return nil, errors.New("the exec health checker is disabled")
+	} else if !isExecCommandAllowed(c.Command) {
+		return nil, fmt.Errorf("the command '%s' is not allowed", c.Command)
+	}
+
+	return func(ctx context.Context, addrOrURL string) error {
+		cmd := exec.CommandContext(ctx, c.Command, c.Args...)
+		cmd.Env = append(os.Environ(), "HEALTHCHECK_ADDR="+addrOrURL)
+		if addr, err := getHostPort(addrOrURL); err == nil {
+			if host, port, err := net.SplitHostPort(addr); err == nil {
+				cmd.Env = append(cmd.Env, "HEALTHCHECK_HOST="+host, "HEALTHCHECK_PORT="+port)
+			}
+		}
+
+		if output, err := cmd.CombinedOutput(); err != nil {
+			if len(output) > 256 {
+				output = output[:256]
+			}
+			return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+		}
+		return nil
+	}, nil
+}
+
+func isExecCommandAllowed(command string) bool {
+	if !filepath.IsAbs(command) {
+		return false
+	}
+
+	command = filepath.Clean(command)
+	for _, allowed := range ExecHealthCheckCommands {
+		if filepath.Clean(allowed) == command {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xgfone/apigw/forward/lb"
+)
+
+// DrainState is the state of the endpoint being drained from the forwarder.
+type DrainState struct {
+	Forwarder string    `json:"forwarder"`
+	Deadline  time.Time `json:"deadline"`
+	Inflight  int64     `json:"inflight"`
+}
+
+// drains is the forwarders draining the endpoints, which is keyed by
+// the address of the endpoint.
+var drains = struct {
+	lock       sync.Mutex
+	forwarders map[string]map[*Forwarder]struct{}
+}{forwarders: make(map[string]map[*Forwarder]struct{})}
+
+func registerDrain(addr string, f *Forwarder) {
+	drains.lock.Lock()
+	fs, ok := drains.forwarders[addr]
+	if !ok {
+		fs = make(map[*Forwarder]struct{}, 2)
+		drains.forwarders[addr] = fs
+	}
+	fs[f] = struct{}{}
+	drains.lock.Unlock()
+}
+
+func unregisterDrain(addr string, f *Forwarder) {
+	drains.lock.Lock()
+	if fs, ok := drains.forwarders[addr]; ok {
+		delete(fs, f)
+		if len(fs) == 0 {
+			delete(drains.forwarders, addr)
+		}
+	}
+	drains.lock.Unlock()
+}
+
+// GetDrainStates returns the states of the endpoint being drained
+// from the forwarders by the address.
+func GetDrainStates(addr string) (states []DrainState) {
+	drains.lock.Lock()
+	fs := make([]*Forwarder, 0, len(drains.forwarders[addr]))
+	for f := range drains.forwarders[addr] {
+		fs = append(fs, f)
+	}
+	drains.lock.Unlock()
+
+	for _, f := range fs {
+		if state, ok := f.drainState(addr); ok {
+			states = append(states, state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Forwarder < states[j].Forwarder })
+	return
+}
+
+// DrainGroupBackends deletes the backends from the backend group gracefully,
+// which are drained from all the route forwarders using the backend group.
+// See Forwarder.DrainBackends.
+func DrainGroupBackends(group lb.BackendGroup, timeout time.Duration, backends ...lb.Backend) {
+	deadline := time.Now().Add(timeout)
+	for _, b := range backends {
+		for _, updater := range group.GetUpdaters() {
+			if f, ok := updater.(*Forwarder); ok {
+				f.drainBackend(b, deadline)
+			}
+		}
+		group.DelBackend(b)
+	}
+}
+
+// DrainBackends deletes the backends from the forwarder gracefully.
+//
+// The backends are deleted from the forwarder at once, so they are not
+// selected for the new requests. But they still serve the in-flight requests
+// and the existing sessions until the deadline, then are deleted completely.
+// If the backend is added again before the deadline, cancel the draining.
+//
+// For the backend group, all its backends are drained.
+func (f *Forwarder) DrainBackends(timeout time.Duration, backends ...lb.Backend) {
+	deadline := time.Now().Add(timeout)
+
+	f.ulock.Lock()
+	defer f.ulock.Unlock()
+
+	for _, b := range backends {
+		addr := b.String()
+		f.lock.Lock()
+		b, ok := f.backends[addr]
+		delete(f.backends, addr)
+		f.lock.Unlock()
+		if !ok {
+			continue
+		}
+
+		if gb, ok := lb.UnwrapBackend(b).(lb.BackendGroup); ok {
+			for _, backend := range gb.GetBackends() {
+				f.drainBackend(backend, deadline)
+			}
+			gb.DelUpdater(f)
+			f.updatePolicy()
+		} else {
+			f.drainBackend(b, deadline)
+		}
+	}
+}
+
+// drainBackend stops selecting the backend for the new requests,
+// and deletes it after the deadline.
+func (f *Forwarder) drainBackend(b lb.Backend, deadline time.Time) {
+	addr := b.String()
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	if ok {
+		b = leaf.backend
+		leaf.stopDraining()
+		leaf.deadline = deadline
+		leaf.timer = time.AfterFunc(time.Until(deadline), func() {
+			f.ulock.Lock()
+			defer f.ulock.Unlock()
+
+			f.lock.RLock()
+			leaf, ok := f.leaves[addr]
+			expired := ok && leaf.deadline.Equal(deadline)
+			f.lock.RUnlock()
+
+			if expired {
+				f.removeBackend(b)
+			}
+		})
+	}
+	f.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	f.provider.DelEndpoint(b)
+	registerDrain(addr, f)
+}
+
+// undrainBackend cancels the draining of the backend.
+func (f *Forwarder) undrainBackend(addr string) {
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	if ok {
+		leaf.stopDraining()
+	}
+	f.lock.Unlock()
+
+	if ok {
+		unregisterDrain(addr, f)
+		if IsEndpointOnline(addr) {
+			f.AddEndpoint(leaf.backend)
+		}
+	}
+}
+
+func (f *Forwarder) drainState(addr string) (state DrainState, ok bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	leaf, ok := f.leaves[addr]
+	if !ok || leaf.deadline.IsZero() {
+		return DrainState{}, false
+	}
+
+	return DrainState{
+		Forwarder: f.name,
+		Deadline:  leaf.deadline,
+		Inflight:  leaf.stats.Inflight(),
+	}, true
+}
@@ -0,0 +1,792 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/go-service/loadbalancer"
+	"github.com/xgfone/ship/v3"
+)
+
+// ForwarderConfig is the configuration of the route forwarder.
+type ForwarderConfig struct {
+	// MaxTimeout is the maximum timeout to forward the request,
+	// which is DefaultForwarderMaxTimeout by default.
+	MaxTimeout string `json:"max_timeout,omitempty"`
+
+	// PolicyConfig is the load-balancing policy. If the policy is empty,
+	// inherit it from the backend groups of the route, or use DefaultPolicy.
+	PolicyConfig
+
+	// Session is the session stickiness, which binds the requests with
+	// the same remote address to the same backend by default.
+	Session *SessionConfig `json:"session,omitempty"`
+
+	// Outlier is the passive outlier detection, which is disabled if nil.
+	Outlier *OutlierConfig `json:"outlier,omitempty"`
+
+	// Retry is the retry policy.
+	//
+	// If nil, the request is only failed over to another endpoint when
+	// failing to connect to the endpoint, which has not received it.
+	// Set MaxAttempts to 1 to disable the failover.
+	Retry *RetryConfig `json:"retry,omitempty"`
+}
+
+// Equal reports whether the configuration is equal to other.
+func (c ForwarderConfig) Equal(other ForwarderConfig) bool {
+	return reflect.DeepEqual(c, other)
+}
+
+// IsZero reports whether the configuration is ZERO.
+func (c ForwarderConfig) IsZero() bool { return c.Equal(ForwarderConfig{}) }
+
+// MaskSecrets returns a copy of the configuration, the inline session secret
+// of which is masked as MaskedSessionSecret.
+func (c ForwarderConfig) MaskSecrets() ForwarderConfig {
+	if c.Session != nil && c.Session.Secret != "" {
+		session := *c.Session
+		session.Secret = MaskedSessionSecret
+		c.Session = &session
+	}
+	return c
+}
+
+// UnmaskSecrets returns a copy of the configuration, the masked session
+// secret of which is replaced with the one of the current configuration.
+func (c ForwarderConfig) UnmaskSecrets(current ForwarderConfig) ForwarderConfig {
+	if c.Session != nil && c.Session.Secret == MaskedSessionSecret &&
+		current.Session != nil && current.Session.Secret != "" {
+		session := *c.Session
+		session.Secret = current.Session.Secret
+		c.Session = &session
+	}
+	return c
+}
+
+type forwarderSettings struct {
+	conf    ForwarderConfig
+	timeout time.Duration
+	session *sessionAffinity // nil represents no session stickiness.
+	outlier *outlierDetector // nil represents no outlier detection.
+	retry   *retryPolicy     // nil represents the default failover.
+}
+
+func (c ForwarderConfig) settings() (s *forwarderSettings, err error) {
+	s = &forwarderSettings{conf: c, timeout: DefaultForwarderMaxTimeout}
+	if c.MaxTimeout != "" {
+		if s.timeout, err = time.ParseDuration(c.MaxTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Policy != "" {
+		if _, err = NewSelector(c.PolicyConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	var session SessionConfig
+	if c.Session != nil {
+		session = *c.Session
+	}
+	if s.session, err = session.newSessionAffinity(); err != nil {
+		return nil, err
+	}
+
+	if c.Outlier != nil {
+		if s.outlier, err = c.Outlier.newOutlierDetector(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Retry != nil {
+		if s.retry, err = c.Retry.newRetryPolicy(); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// Validate validates whether the configuration is valid.
+func (c ForwarderConfig) Validate() (err error) {
+	_, err = c.settings()
+	return
+}
+
+var (
+	_ lb.Forwarder                         = &Forwarder{}
+	_ lb.BackendGroupUpdater               = &Forwarder{}
+	_ loadbalancer.ProviderEndpointManager = &Forwarder{}
+)
+
+// Forwarder is the route forwarder based on the loadbalancer, whose backends
+// and settings can be updated in place without re-registering the route.
+//
+// The requests being forwarded go on with the old settings, and the new
+// requests use the new ones.
+type Forwarder struct {
+	name     string
+	provider *loadbalancer.GeneralProvider
+	settings atomic.Value // *forwarderSettings
+
+	plock    sync.Mutex
+	policy   PolicyConfig // The policy in use, which may be inherited.
+	selector loadbalancer.Selector
+
+	ulock    sync.Mutex // Serialize the updates of the backends.
+	lock     sync.RWMutex
+	backends map[string]lb.Backend
+	leaves   map[string]*leafBackend // All the backends including the ones of the groups.
+}
+
+// NewForwarder returns a new route forwarder.
+func NewForwarder(name string, conf ForwarderConfig) (*Forwarder, error) {
+	settings, err := conf.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forwarder{
+		name:     name,
+		provider: loadbalancer.NewGeneralProvider(nil),
+		backends: make(map[string]lb.Backend),
+		leaves:   make(map[string]*leafBackend),
+	}
+	f.settings.Store(settings)
+	f.updatePolicy()
+	return f, nil
+}
+
+func (f *Forwarder) getSettings() *forwarderSettings {
+	return f.settings.Load().(*forwarderSettings)
+}
+
+// Config returns the configuration of the forwarder.
+func (f *Forwarder) Config() ForwarderConfig { return f.getSettings().conf }
+
+// Update updates the configuration of the forwarder in place,
+// which keeps the backends and their health status.
+//
+// The state of the load-balancing policy and the session stickiness is kept
+// if they are not changed.
+func (f *Forwarder) Update(conf ForwarderConfig) (err error) {
+	settings, err := conf.settings()
+	if err != nil {
+		return
+	}
+
+	if old := f.getSettings(); reflect.DeepEqual(conf.Session, old.conf.Session) {
+		settings.session = old.session
+	}
+
+	f.settings.Store(settings)
+	f.updatePolicy()
+	return
+}
+
+// Policy returns the load-balancing policy in use, which may be inherited
+// from the backend groups.
+func (f *Forwarder) Policy() PolicyConfig {
+	f.plock.Lock()
+	defer f.plock.Unlock()
+	return f.policy
+}
+
+// updatePolicy resets the selector if the load-balancing policy in use
+// is changed.
+//
+// The policy of the forwarder takes precedence over the one of the backend
+// groups, which are tried in order of the name.
+func (f *Forwarder) updatePolicy() {
+	policy := f.Config().PolicyConfig
+	if policy.Policy == "" {
+		f.lock.RLock()
+		groups := make([]lb.BackendGroup, 0, 2)
+		for _, b := range f.backends {
+			if g, ok := lb.UnwrapBackend(b).(lb.BackendGroup); ok {
+				groups = append(groups, g)
+			}
+		}
+		f.lock.RUnlock()
+
+		sort.Slice(groups, func(i, j int) bool { return groups[i].Name() < groups[j].Name() })
+		for _, g := range groups {
+			if gp := GetGroupConfig(g).PolicyConfig; gp.Policy != "" {
+				policy = gp
+				break
+			}
+		}
+	}
+	if policy.Policy == "" {
+		policy.Policy = DefaultPolicy
+	}
+
+	f.plock.Lock()
+	defer f.plock.Unlock()
+	if policy == f.policy {
+		return
+	}
+
+	// The policy has been validated, so the error is ignored in theory.
+	if selector, err := NewSelector(policy); err == nil {
+		f.provider.SetSelector(selector)
+		f.selector = selector
+		f.policy = policy
+	}
+}
+
+// Name implements the interface lb.Forwarder.
+func (f *Forwarder) Name() string { return f.name }
+
+// Close implements the interface lb.Forwarder.
+func (f *Forwarder) Close() error {
+	f.lock.RLock()
+	backends := make([]lb.Backend, 0, len(f.backends))
+	for _, backend := range f.backends {
+		backends = append(backends, backend)
+	}
+	f.lock.RUnlock()
+	f.DelBackends(backends...)
+
+	// Delete the backends being drained at once.
+	f.ulock.Lock()
+	f.lock.RLock()
+	backends = make([]lb.Backend, 0, len(f.leaves))
+	for _, leaf := range f.leaves {
+		if leaf.backend != nil {
+			backends = append(backends, leaf.backend)
+		}
+	}
+	f.lock.RUnlock()
+	for _, b := range backends {
+		f.removeBackend(b)
+	}
+	f.ulock.Unlock()
+
+	return f.provider.Close()
+}
+
+// Forward implements the interface lb.Forwarder.
+func (f *Forwarder) Forward(ctx *apigw.Context) (err error) {
+	settings := f.getSettings()
+
+	c := context.Background()
+	if settings.timeout > 0 {
+		var cancel func()
+		c, cancel = context.WithTimeout(c, settings.timeout)
+		defer cancel()
+	}
+
+	req := forwardRequest{ctx: ctx}
+	ep := f.selectEndpoint(settings.session, req)
+	if ep == nil {
+		return ship.ErrBadGateway.New(lb.ErrNoAvailableBackends)
+	}
+
+	if settings.retry != nil {
+		err = f.forwardWithRetry(c, settings, settings.retry, req, ep)
+	} else {
+		failover := *failoverPolicy
+		failover.maxAttempts = f.provider.Len()
+		err = f.forwardWithRetry(c, settings, &failover, req, ep)
+	}
+
+	switch err {
+	case loadbalancer.ErrNoAvailableEndpoint, lb.ErrNoAvailableBackends:
+		err = ship.ErrBadGateway.New(lb.ErrNoAvailableBackends)
+	}
+	return
+}
+
+// roundTrip forwards the request to the endpoint once.
+func (f *Forwarder) roundTrip(c context.Context, s *forwarderSettings,
+	req forwardRequest, ep loadbalancer.Endpoint) (err error) {
+	_, err = ep.RoundTrip(c, req)
+	if s.outlier != nil {
+		f.checkOutlier(s.outlier, ep.String(), req.ctx, err)
+	}
+	return
+}
+
+// selectEndpoint returns the endpoint bound to the session of the request
+// if it is still active, or selects a new one by the policy.
+func (f *Forwarder) selectEndpoint(s *sessionAffinity, req forwardRequest) (
+	ep loadbalancer.Endpoint) {
+	if s == nil {
+		return f.failover(req, f.provider.Select(req), nil)
+	}
+
+	if addr := s.Get(req.ctx, f.leafAddrs); addr != "" {
+		if ep = f.getActiveEndpoint(addr); ep != nil && isEndpointAvailable(ep) {
+			return
+		}
+	}
+
+	if ep = f.failover(req, f.provider.Select(req), nil); ep != nil {
+		s.Set(req.ctx, ep.String())
+	}
+	return
+}
+
+// failover selects another endpoint by the policy if the circuit breaker
+// of the selected endpoint is open or it is in excludes.
+//
+// If no other endpoints are available, ignore excludes. If the circuit
+// breakers of all the endpoints are open, return the selected endpoint
+// to fail fast.
+func (f *Forwarder) failover(req forwardRequest, ep loadbalancer.Endpoint,
+	excludes map[string]struct{}) loadbalancer.Endpoint {
+	available := func(ep loadbalancer.Endpoint) bool {
+		_, excluded := excludes[ep.String()]
+		return !excluded && isEndpointAvailable(ep)
+	}
+
+	if ep == nil || available(ep) {
+		return ep
+	}
+
+	eps := f.provider.Endpoints()
+	availables := make(loadbalancer.Endpoints, 0, len(eps))
+	for _, e := range eps {
+		if available(e) {
+			availables = append(availables, e)
+		}
+	}
+	if len(availables) == 0 {
+		if len(excludes) > 0 {
+			return f.failover(req, ep, nil)
+		}
+		return ep
+	}
+
+	f.plock.Lock()
+	selector := f.selector
+	f.plock.Unlock()
+	return selector.Select(req, availables)
+}
+
+// leafAddrs returns the addresses of all the backends including
+// the ones of the groups.
+func (f *Forwarder) leafAddrs() []string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	addrs := make([]string, 0, len(f.leaves))
+	for addr := range f.leaves {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// getActiveEndpoint returns the active endpoint by the address.
+//
+// Return nil if the endpoint does not exist or is not active.
+// The draining endpoint is still active for the existing sessions
+// until the deadline if it is healthy.
+func (f *Forwarder) getActiveEndpoint(addr string) (ep loadbalancer.Endpoint) {
+	var deadline time.Time
+	f.lock.RLock()
+	if leaf, ok := f.leaves[addr]; ok {
+		ep, deadline = leaf.endpoint, leaf.deadline
+	}
+	f.lock.RUnlock()
+
+	switch {
+	case ep == nil:
+	case !deadline.IsZero():
+		if time.Now().After(deadline) || !IsEndpointOnline(addr) {
+			ep = nil
+		}
+	case !f.provider.IsActive(ep):
+		ep = nil
+	}
+	return
+}
+
+// Endpoints implements the interface loadbalancer.ProviderEndpointManager.
+func (f *Forwarder) Endpoints() loadbalancer.Endpoints {
+	return f.provider.Endpoints()
+}
+
+// AddEndpoint implements the interface loadbalancer.ProviderEndpointManager.
+//
+// The endpoint is wrapped to collect the in-flight requests and the latency,
+// which are used by the load-balancing policies.
+//
+// The endpoint administratively down or ejected as the outlier is not added.
+func (f *Forwarder) AddEndpoint(ep loadbalancer.Endpoint) {
+	addr := ep.String()
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	if !ok {
+		leaf = &leafBackend{}
+		f.leaves[addr] = leaf
+	}
+	if leaf.backend != nil {
+		// The health checker may notify the endpoint added by other forwarders
+		// with the same address, so use its own to keep its own weight.
+		ep = leaf.backend
+	}
+	leaf.endpoint = statsEndpoint{Endpoint: ep, stats: &leaf.stats}
+	draining := !leaf.deadline.IsZero()
+	ejected := !leaf.outlier.until.IsZero()
+	f.lock.Unlock()
+
+	// The draining or ejected endpoint is not selected for the new requests.
+	if !draining && !ejected && GetEndpointOverride(addr) != AdminStateDown {
+		f.provider.AddEndpoint(leaf.endpoint)
+	}
+}
+
+// DelEndpoint implements the interface loadbalancer.ProviderEndpointManager.
+//
+// The endpoint administratively up is not deleted.
+func (f *Forwarder) DelEndpoint(ep loadbalancer.Endpoint) {
+	if GetEndpointOverride(ep.String()) != AdminStateUp {
+		f.provider.DelEndpoint(ep)
+	}
+}
+
+// refreshEndpoint adds or deletes the endpoint by the address according to
+// whether it is online, after its administrative state is changed.
+func (f *Forwarder) refreshEndpoint(addr string) {
+	f.lock.RLock()
+	leaf, ok := f.leaves[addr]
+	ok = ok && leaf.backend != nil && leaf.deadline.IsZero()
+	f.lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	if IsEndpointOnline(addr) {
+		f.AddEndpoint(leaf.backend)
+	} else {
+		f.provider.DelEndpoint(leaf.backend)
+	}
+}
+
+// AddBackendFromGroup implements the interface lb.BackendGroupUpdater.
+func (f *Forwarder) AddBackendFromGroup(b lb.Backend) { f.addBackend(b) }
+
+// DelBackendFromGroup implements the interface lb.BackendGroupUpdater.
+func (f *Forwarder) DelBackendFromGroup(b lb.Backend) {
+	if _, ok := b.(lb.BackendGroup); ok {
+		addr := b.String()
+		f.lock.Lock()
+		delete(f.backends, addr)
+		f.lock.Unlock()
+		f.updatePolicy()
+		return
+	}
+
+	f.delBackend(b)
+}
+
+// GetBackends implements the interface lb.Forwarder.
+func (f *Forwarder) GetBackends() []lb.Backend {
+	online := func(b lb.Backend) bool {
+		if IsEndpointOnline(b.String()) {
+			return true
+		} else if _, ok := lb.UnwrapBackend(b).(lb.BackendGroup); ok {
+			return b.IsHealthy(context.Background())
+		}
+		return false
+	}
+
+	f.lock.RLock()
+	bs := make([]lb.Backend, 0, len(f.backends))
+	for _, b := range f.backends {
+		bs = append(bs, onlineBackend{Backend: b, online: online(b)})
+	}
+	f.lock.RUnlock()
+	return bs
+}
+
+// AddBackends implements the interface lb.Forwarder.
+//
+// If the backend has existed, update its weight in place, or replace it
+// if its configuration is changed.
+func (f *Forwarder) AddBackends(backends ...lb.Backend) {
+	f.ulock.Lock()
+	defer f.ulock.Unlock()
+	for _, b := range backends {
+		f.addRouteBackend(b)
+	}
+}
+
+// DelBackends implements the interface lb.Forwarder.
+func (f *Forwarder) DelBackends(backends ...lb.Backend) {
+	f.ulock.Lock()
+	defer f.ulock.Unlock()
+	for _, b := range backends {
+		f.delRouteBackend(b)
+	}
+}
+
+// AddBackend implements the interface lb.Forwarder.
+func (f *Forwarder) AddBackend(b lb.Backend) { f.AddBackends(b) }
+
+// DelBackend implements the interface lb.Forwarder.
+func (f *Forwarder) DelBackend(b lb.Backend) { f.DelBackends(b) }
+
+// SetBackends replaces all the backends of the forwarder with the given.
+//
+// The new backends are added before the old are deleted, so there is always
+// the backend to forward the request during the replacement.
+func (f *Forwarder) SetBackends(backends ...lb.Backend) {
+	f.ulock.Lock()
+	defer f.ulock.Unlock()
+
+	news := make(map[string]struct{}, len(backends))
+	for _, b := range backends {
+		news[b.String()] = struct{}{}
+		f.addRouteBackend(b)
+	}
+
+	f.lock.RLock()
+	olds := make([]lb.Backend, 0, len(f.backends))
+	for addr, b := range f.backends {
+		if _, ok := news[addr]; !ok {
+			olds = append(olds, b)
+		}
+	}
+	f.lock.RUnlock()
+
+	for _, b := range olds {
+		f.delRouteBackend(b)
+	}
+}
+
+func (f *Forwarder) addRouteBackend(b lb.Backend) {
+	addr := b.String()
+	f.lock.RLock()
+	old, ok := f.backends[addr]
+	f.lock.RUnlock()
+
+	if ok {
+		// Update the weight in place, or replace the backend with
+		// the different configuration, such as the health check.
+		if UpdateBackend(old, b) {
+			return
+		}
+		f.delRouteBackend(old)
+	}
+
+	f.lock.Lock()
+	f.backends[addr] = b
+	f.lock.Unlock()
+
+	if gb, ok := lb.UnwrapBackend(b).(lb.BackendGroup); ok {
+		gb.AddUpdater(f)
+		f.updatePolicy()
+	} else {
+		f.addBackend(b)
+	}
+}
+
+func (f *Forwarder) delRouteBackend(b lb.Backend) {
+	addr := b.String()
+	f.lock.Lock()
+	b, ok := f.backends[addr]
+	if !ok {
+		f.lock.Unlock()
+		return
+	}
+	delete(f.backends, addr)
+	f.lock.Unlock()
+
+	if gb, ok := lb.UnwrapBackend(b).(lb.BackendGroup); ok {
+		gb.DelUpdater(f)
+		f.updatePolicy()
+	} else {
+		f.delBackend(b)
+	}
+}
+
+func (f *Forwarder) addBackend(b lb.Backend) {
+	addr := b.String()
+	var old lb.Backend
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	if ok {
+		old, leaf.backend = leaf.backend, b
+	} else {
+		f.leaves[addr] = &leafBackend{backend: b}
+	}
+	draining := ok && !leaf.deadline.IsZero()
+	f.lock.Unlock()
+
+	acquireHTTPClient(b)
+	if old != nil {
+		releaseHTTPClient(old)
+	}
+
+	// The draining backend is added again, so cancel the draining,
+	// and it has been added into the health checker.
+	if draining {
+		f.undrainBackend(addr)
+		return
+	}
+
+	registerForwarder(addr, f)
+	addHealthCheckEndpoint(b)
+
+	// The endpoint may have been checked as healthy by other forwarders,
+	// so it won't be notified again.
+	if IsEndpointOnline(addr) {
+		f.AddEndpoint(b)
+	}
+}
+
+func (f *Forwarder) delBackend(b lb.Backend) {
+	addr := b.String()
+	f.lock.RLock()
+	leaf, ok := f.leaves[addr]
+	draining := ok && !leaf.deadline.IsZero()
+	f.lock.RUnlock()
+
+	// The draining backend will be deleted after the deadline.
+	if !draining {
+		f.removeBackend(b)
+	}
+}
+
+func (f *Forwarder) removeBackend(b lb.Backend) {
+	addr := b.String()
+	unregisterForwarder(addr, f)
+	HC.DelEndpoint(b)
+	forgetEndpointHistory(addr)
+	f.provider.DelEndpoint(b)
+
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	if ok {
+		leaf.stopDraining()
+		delete(f.leaves, addr)
+	}
+	f.lock.Unlock()
+	unregisterDrain(addr, f)
+
+	if ok && leaf.backend != nil {
+		releaseHTTPClient(leaf.backend)
+	}
+}
+
+// forwarders is the forwarders referring to the endpoints, which is keyed
+// by the address of the endpoint, including the ones being drained.
+var forwarders = struct {
+	lock       sync.RWMutex
+	forwarders map[string]map[*Forwarder]struct{}
+}{forwarders: make(map[string]map[*Forwarder]struct{})}
+
+func registerForwarder(addr string, f *Forwarder) {
+	forwarders.lock.Lock()
+	fs, ok := forwarders.forwarders[addr]
+	if !ok {
+		fs = make(map[*Forwarder]struct{}, 2)
+		forwarders.forwarders[addr] = fs
+	}
+	fs[f] = struct{}{}
+	forwarders.lock.Unlock()
+}
+
+func unregisterForwarder(addr string, f *Forwarder) {
+	forwarders.lock.Lock()
+	if fs, ok := forwarders.forwarders[addr]; ok {
+		delete(fs, f)
+		if len(fs) == 0 {
+			delete(forwarders.forwarders, addr)
+		}
+	}
+	forwarders.lock.Unlock()
+}
+
+// getForwarders returns the forwarders referring to the endpoint by the address.
+func getForwarders(addr string) []*Forwarder {
+	forwarders.lock.RLock()
+	defer forwarders.lock.RUnlock()
+
+	fs := make([]*Forwarder, 0, len(forwarders.forwarders[addr]))
+	for f := range forwarders.forwarders[addr] {
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+// forwarderNotifier is subscribed to the health checker to notify
+// the forwarders referring to the endpoint of its health transitions.
+type forwarderNotifier struct{}
+
+func (n forwarderNotifier) Name() string { return "route_forwarders" }
+
+func (n forwarderNotifier) AddEndpoint(ep loadbalancer.Endpoint) {
+	for _, f := range getForwarders(ep.String()) {
+		f.AddEndpoint(ep)
+	}
+}
+
+func (n forwarderNotifier) DelEndpoint(ep loadbalancer.Endpoint) {
+	for _, f := range getForwarders(ep.String()) {
+		f.DelEndpoint(ep)
+	}
+}
+
+// leafBackend is the backend added into the health checker by the forwarder.
+type leafBackend struct {
+	backend  lb.Backend
+	endpoint loadbalancer.Endpoint // The endpoint added into the provider.
+	stats    endpointStats
+	outlier  outlierState
+	deadline time.Time   // The deadline of the draining, which is ZERO if not.
+	timer    *time.Timer // The timer to delete the drained backend.
+}
+
+// stopDraining stops the timer of the draining, which must be called
+// with the lock of the forwarder.
+func (l *leafBackend) stopDraining() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.deadline = time.Time{}
+}
+
+type forwardRequest struct{ ctx *apigw.Context }
+
+func (r forwardRequest) Context() *apigw.Context  { return r.ctx }
+func (r forwardRequest) RemoteAddrString() string { return r.ctx.RemoteAddr() }
+
+type onlineBackend struct {
+	lb.Backend
+	online bool
+}
+
+func (b onlineBackend) Unwrap() loadbalancer.Endpoint  { return b.Backend }
+func (b onlineBackend) IsHealthy(context.Context) bool { return b.online }
+func (b onlineBackend) MetaData() map[string]interface{} {
+	md := b.Backend.MetaData()
+	md["online"] = b.online
+	return md
+}
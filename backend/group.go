@@ -0,0 +1,133 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/xgfone/apigw/forward/lb"
+)
+
+// GroupConfig is the configuration of the backend group.
+type GroupConfig struct {
+	// PolicyConfig is the load-balancing policy, which is inherited by
+	// the routes using the backend group without their own policy.
+	PolicyConfig
+
+	// Transport is the options of the http transport, which is inherited
+	// by the http backends in the group without the metadata "transport".
+	Transport *TransportConfig `json:"transport,omitempty"`
+}
+
+// Validate validates whether the configuration is valid.
+func (c GroupConfig) Validate() (err error) {
+	if c.Policy != "" {
+		if _, err = NewSelector(c.PolicyConfig); err != nil {
+			return
+		}
+	}
+	if c.Transport != nil {
+		if _, err = c.Transport.TransportOptions(DefaultTransportOptions); err != nil {
+			return fmt.Errorf("invalid transport: %s", err)
+		}
+	}
+	return
+}
+
+// groupSettings is stored as the user data of the backend group,
+// so the configuration can be updated in place.
+type groupSettings struct{ conf atomic.Value }
+
+// NewGroupBackendConfig returns the configuration to build the backend group
+// with the group configuration.
+func NewGroupBackendConfig(conf GroupConfig) (*lb.GroupBackendConfig, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	settings := new(groupSettings)
+	settings.conf.Store(conf)
+	return &lb.GroupBackendConfig{IsHealthy: IsHealthy, UserData: settings}, nil
+}
+
+// GetGroupConfig returns the configuration of the backend group.
+//
+// If the backend group is not built by NewGroupBackendConfig, return ZERO.
+func GetGroupConfig(group lb.BackendGroup) GroupConfig {
+	if s := getGroupSettings(group); s != nil {
+		return s.conf.Load().(GroupConfig)
+	}
+	return GroupConfig{}
+}
+
+func getGroupSettings(group lb.BackendGroup) *groupSettings {
+	if b, ok := group.(lb.Backend); ok {
+		s, _ := b.UserData().(*groupSettings)
+		return s
+	}
+	return nil
+}
+
+// UpdateGroupConfig updates the configuration of the backend group in place,
+// and the policy of the route forwarders using it.
+//
+// If the transport is changed, the backends inheriting it are rebuilt,
+// and the http clients of the replaced backends are released.
+func UpdateGroupConfig(group lb.BackendGroup, conf GroupConfig) error {
+	s := getGroupSettings(group)
+	if s == nil {
+		return fmt.Errorf("the backend group '%s' does not support to be updated", group.Name())
+	} else if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	// Build the new backends in advance in order not to update partially.
+	var olds, news []lb.Backend
+	if old := s.conf.Load().(GroupConfig); !reflect.DeepEqual(old.Transport, conf.Transport) {
+		for _, b := range group.GetBackends() {
+			cb, ok := getConfigBackend(b)
+			if !ok || !cb.conf.inheritTransport() {
+				continue
+			}
+
+			bconf := cb.conf
+			bconf.Weight = int(atomic.LoadInt32(cb.weight))
+			nb, err := bconf.backend(cb.route, conf.Transport)
+			if err != nil {
+				for _, nb := range news {
+					discardHTTPClient(nb)
+				}
+				return fmt.Errorf("fail to rebuild the backend '%s': %s", b.String(), err)
+			}
+			olds, news = append(olds, b), append(news, nb)
+		}
+	}
+
+	s.conf.Store(conf)
+	for i := range olds {
+		group.DelBackend(olds[i])
+		group.AddBackend(news[i])
+		discardHTTPClient(olds[i])
+	}
+
+	for _, updater := range group.GetUpdaters() {
+		if f, ok := updater.(*Forwarder); ok {
+			f.updatePolicy()
+		}
+	}
+	return nil
+}
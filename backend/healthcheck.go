@@ -0,0 +1,168 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// DefaultHealthCheckInterval is the default interval of the health check.
+const DefaultHealthCheckInterval = time.Second * 10
+
+// HealthCheckConfig is the default configuration of the health check,
+// which is used by the backends without their own.
+type HealthCheckConfig struct {
+	// Interval is the interval between two probes of the backend.
+	Interval time.Duration
+
+	// Timeout is the timeout of a probe, which is not limited if ZERO.
+	Timeout time.Duration
+
+	// RetryNum is the number of the extra failed probes before the backend
+	// is marked as unhealthy.
+	RetryNum int
+
+	// Concurrency is the maximum number of the probes running concurrently,
+	// which is not limited if ZERO.
+	Concurrency int
+}
+
+var (
+	hcLock sync.RWMutex
+	hcConf = HealthCheckConfig{Interval: DefaultHealthCheckInterval}
+
+	// probeSem is the semaphore to limit the concurrent probes,
+	// which is nil if not limited.
+	probeSem atomic.Value // chan struct{}
+)
+
+func init() { probeSem.Store((chan struct{})(nil)) }
+
+// GetHealthCheckConfig returns the default configuration of the health check.
+func GetHealthCheckConfig() HealthCheckConfig {
+	hcLock.RLock()
+	defer hcLock.RUnlock()
+	return hcConf
+}
+
+// SetHealthCheckConfig resets the default configuration of the health check,
+// which is applied to the endpoints being checked immediately.
+func SetHealthCheckConfig(c HealthCheckConfig) error {
+	if c.Interval <= 0 {
+		return fmt.Errorf("invalid health check interval '%s'", c.Interval)
+	} else if c.Timeout < 0 {
+		return fmt.Errorf("invalid health check timeout '%s'", c.Timeout)
+	} else if c.RetryNum < 0 {
+		return fmt.Errorf("invalid health check retry number '%d'", c.RetryNum)
+	} else if c.Concurrency < 0 {
+		return fmt.Errorf("invalid health check concurrency '%d'", c.Concurrency)
+	}
+
+	hcLock.Lock()
+	defer hcLock.Unlock()
+
+	if c.Concurrency != hcConf.Concurrency {
+		// The running probes release the slots into the old semaphore,
+		// so the limit may be exceeded temporarily.
+		var sem chan struct{}
+		if c.Concurrency > 0 {
+			sem = make(chan struct{}, c.Concurrency)
+		}
+		probeSem.Store(sem)
+	}
+	hcConf = c
+
+	for _, ep := range HC.Endpoints() {
+		reset, ok := ep.(interface {
+			Reset(interval, timeout time.Duration, retryNum int)
+		})
+		if !ok {
+			continue
+		}
+
+		if eu, ok := ep.(loadbalancer.EndpointUnwrap); ok {
+			if b, ok := eu.Unwrap().(lb.Backend); ok {
+				hc := fillHealthCheck(b.HealthCheck(), c)
+				reset.Reset(hc.Interval, hc.Timeout, hc.RetryNum)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addHealthCheckEndpoint adds the backend into the health checker,
+// whose unset health check settings are filled with the default ones.
+func addHealthCheckEndpoint(b lb.Backend) {
+	hcLock.RLock()
+	defer hcLock.RUnlock()
+
+	hc := fillHealthCheck(b.HealthCheck(), hcConf)
+	HC.AddEndpointWithDuration(probeEndpoint{b}, hc.Interval, hc.Timeout, hc.RetryNum)
+}
+
+// fillHealthCheck fills the unset health check settings of the backend
+// with the default configuration.
+func fillHealthCheck(hc lb.HealthCheck, c HealthCheckConfig) lb.HealthCheck {
+	if hc.Interval == 0 {
+		hc.Interval = c.Interval
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = c.Timeout
+	}
+	if hc.RetryNum == 0 {
+		hc.RetryNum = c.RetryNum
+	}
+	return hc
+}
+
+// probeEndpoint is the backend added into the health checker,
+// which limits the concurrent probes and records their results.
+type probeEndpoint struct{ lb.Backend }
+
+func (b probeEndpoint) Unwrap() loadbalancer.Endpoint { return b.Backend }
+func (b probeEndpoint) UnwrapBackend() lb.Backend     { return b.Backend }
+
+func (b probeEndpoint) IsHealthy(c context.Context) (healthy bool) {
+	var err error
+	defer func() { recordProbe(b.String(), healthy, err) }()
+	c = withProbeError(c, &err)
+
+	sem := probeSem.Load().(chan struct{})
+	if sem == nil {
+		return b.Backend.IsHealthy(c)
+	}
+
+	// Waiting for the slot does not consume the timeout of the probe.
+	deadline, hasDeadline := c.Deadline()
+	timeout := time.Until(deadline)
+
+	sem <- struct{}{}
+	defer func() { <-sem }()
+
+	if hasDeadline {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(withProbeError(context.Background(), &err), timeout)
+		defer cancel()
+	}
+	return b.Backend.IsHealthy(c)
+}
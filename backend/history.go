@@ -0,0 +1,228 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// MaxHealthTransitions is the maximum number of the health transitions
+// kept for each endpoint.
+var MaxHealthTransitions = 100
+
+// HealthTransition is a transition of the health status of the endpoint.
+type HealthTransition struct {
+	Time    time.Time `json:"time"`
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// HealthUptime is the percents of the time when the endpoint is healthy.
+//
+// If the endpoint has not been checked for the whole period, or the earlier
+// transitions have been discarded, only the recorded time is counted.
+type HealthUptime struct {
+	LastHour float64 `json:"1h"`
+	LastDay  float64 `json:"24h"`
+	Total    float64 `json:"total"`
+}
+
+// HealthHistory is the history of the health transitions of the endpoint.
+type HealthHistory struct {
+	Endpoint    string             `json:"endpoint"`
+	Since       time.Time          `json:"since"`
+	Healthy     bool               `json:"healthy"`
+	Uptime      HealthUptime       `json:"uptime"`
+	Transitions []HealthTransition `json:"transitions"`
+}
+
+type endpointHistory struct {
+	since       time.Time // The time when the endpoint is checked firstly.
+	healthy     bool
+	lastErr     string // The error of the last failed probe.
+	dropped     bool   // Whether the earlier transitions have been discarded.
+	transitions []HealthTransition
+}
+
+var (
+	historyLock sync.Mutex
+	histories   = make(map[string]*endpointHistory, 32)
+)
+
+// historyRecorder is subscribed to the health checker to record the health
+// transitions of all the endpoints.
+type historyRecorder struct{}
+
+func (r historyRecorder) Name() string { return "endpoint_history" }
+
+func (r historyRecorder) AddEndpoint(ep loadbalancer.Endpoint) {
+	recordHealthTransition(ep.String(), true)
+}
+
+func (r historyRecorder) DelEndpoint(ep loadbalancer.Endpoint) {
+	recordHealthTransition(ep.String(), false)
+}
+
+func recordHealthTransition(addr string, healthy bool) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	// Ignore the endpoint which has been removed from the health checker.
+	if h, ok := histories[addr]; ok {
+		h.addTransition(time.Now(), healthy)
+	}
+}
+
+func (h *endpointHistory) addTransition(now time.Time, healthy bool) {
+	if len(h.transitions) > 0 && h.healthy == healthy {
+		return
+	}
+
+	t := HealthTransition{Time: now, Healthy: healthy}
+	if !healthy {
+		t.Error = h.lastErr
+	}
+
+	h.healthy = healthy
+	h.transitions = append(h.transitions, t)
+	if _len := len(h.transitions); MaxHealthTransitions > 0 && _len > MaxHealthTransitions {
+		h.transitions = append([]HealthTransition{}, h.transitions[_len-MaxHealthTransitions:]...)
+		h.dropped = true
+	}
+}
+
+// recordProbe records the result of the probe of the endpoint. The endpoint
+// is unhealthy until its first probe succeeds, so the failure of the first
+// probe is recorded as the first transition.
+func recordProbe(addr string, healthy bool, err error) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	h, ok := histories[addr]
+	if !ok {
+		h = &endpointHistory{since: time.Now()}
+		histories[addr] = h
+	}
+
+	// The error is kept until the endpoint is marked as unhealthy after retrying.
+	if !healthy {
+		if err != nil {
+			h.lastErr = err.Error()
+		} else {
+			h.lastErr = "the health check failed"
+		}
+
+		if !ok {
+			h.addTransition(h.since, false)
+		}
+	}
+}
+
+// forgetEndpointHistory deletes the health history of the endpoint
+// if it has been removed from the health checker.
+func forgetEndpointHistory(addr string) {
+	if !HC.HasEndpoint(addr) {
+		historyLock.Lock()
+		delete(histories, addr)
+		historyLock.Unlock()
+	}
+}
+
+// GetEndpointHistory returns the health history of the endpoint by the address.
+//
+// Return false if the endpoint has not been checked.
+func GetEndpointHistory(addr string) (history HealthHistory, ok bool) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	h, ok := histories[addr]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	history = HealthHistory{
+		Endpoint:    addr,
+		Since:       h.since,
+		Healthy:     h.healthy,
+		Transitions: append([]HealthTransition{}, h.transitions...),
+		Uptime: HealthUptime{
+			LastHour: h.uptime(now, time.Hour),
+			LastDay:  h.uptime(now, time.Hour*24),
+			Total:    h.uptime(now, 0),
+		},
+	}
+	if h.dropped {
+		history.Since = h.transitions[0].Time
+	}
+	return
+}
+
+// uptime returns the percent of the time when the endpoint is healthy
+// in the last window, which is the whole recorded time if ZERO.
+func (h *endpointHistory) uptime(now time.Time, window time.Duration) float64 {
+	start, healthy, transitions := h.since, false, h.transitions
+	if h.dropped {
+		start, healthy = transitions[0].Time, transitions[0].Healthy
+		transitions = transitions[1:]
+	}
+	if window > 0 && now.Sub(start) > window {
+		start = now.Add(-window)
+	}
+
+	total := now.Sub(start)
+	if total <= 0 {
+		if h.healthy {
+			return 100
+		}
+		return 0
+	}
+
+	var up time.Duration
+	last := start
+	for _, t := range transitions {
+		if t.Time.After(last) {
+			if healthy {
+				up += t.Time.Sub(last)
+			}
+			last = t.Time
+		}
+		healthy = t.Healthy
+	}
+	if healthy {
+		up += now.Sub(last)
+	}
+
+	return float64(up) * 100 / float64(total)
+}
+
+type probeErrorKey struct{}
+
+// withProbeError returns a new context to receive the error of the health
+// checker built by NewHealthChecker.
+func withProbeError(c context.Context, err *error) context.Context {
+	return context.WithValue(c, probeErrorKey{}, err)
+}
+
+// setProbeError sets the error of the health checker into the context.
+func setProbeError(c context.Context, err error) {
+	if p, ok := c.Value(probeErrorKey{}).(*error); ok {
+		*p = err
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// maxHealthCheckBodySize is the maximum size of the response body
+// read by the http health check.
+const maxHealthCheckBodySize = 64 * 1024
+
+// HTTPHealthCheck is the specification of the health check of the http
+// backend, which is configured by the metadata "healthcheck" of the backend
+// and whose type is "http".
+//
+// The timeout of the health check is the timeout of the backend.
+type HTTPHealthCheck struct {
+	// Method is the method of the request, which is "GET" by default.
+	Method string `mapstructure:"method" json:"method,omitempty"`
+
+	// Path is the path of the request relative to the backend url,
+	// which may also be an absolute url. It is "/" by default.
+	Path string `mapstructure:"path" json:"path,omitempty"`
+
+	// Headers is the headers of the request, which supports "Host".
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty"`
+
+	// Status is the expected status codes of the response, which are "200",
+	// the range "200-299" or the class "2xx". It is "2xx" by default.
+	Status []string `mapstructure:"status" json:"status,omitempty"`
+
+	// Body is the substring that the response body must contain.
+	Body string `mapstructure:"body" json:"body,omitempty"`
+
+	// BodyRegexp is the regular expression that the response body must match.
+	BodyRegexp string `mapstructure:"body_regexp" json:"body_regexp,omitempty"`
+
+	// TLS is the TLS options for the https health check, which is the
+	// metadata "tls" of the http backend by default.
+	TLS TLSConfig `mapstructure:"tls" json:"tls,omitempty"`
+}
+
+type statusRange struct{ min, max int }
+
+func parseStatusRange(s string) (r statusRange, err error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 3 && strings.EqualFold(s[1:], "xx") {
+		if s[0] < '1' || s[0] > '5' {
+			return r, fmt.Errorf("invalid status class '%s'", s)
+		}
+		r.min = int(s[0]-'0') * 100
+		r.max = r.min + 99
+		return
+	}
+
+	low, high := s, s
+	if index := strings.IndexByte(s, '-'); index > 0 {
+		low, high = strings.TrimSpace(s[:index]), strings.TrimSpace(s[index+1:])
+	}
+
+	if r.min, err = strconv.Atoi(low); err != nil {
+		return r, fmt.Errorf("invalid status '%s'", s)
+	} else if r.max, err = strconv.Atoi(high); err != nil {
+		return r, fmt.Errorf("invalid status '%s'", s)
+	} else if r.min < 100 || r.max > 599 || r.min > r.max {
+		return r, fmt.Errorf("invalid status '%s'", s)
+	}
+	return
+}
+
+type httpHealthChecker struct {
+	spec    HTTPHealthCheck
+	path    *url.URL
+	status  []statusRange
+	regexp  *regexp.Regexp
+	headers http.Header
+
+	// The clients are created for each host to verify its certificate.
+	lock    *sync.Mutex
+	clients map[string]*http.Client
+}
+
+// NewHTTPHealthChecker returns a new health checker of the http backend
+// by the specification, which requests the backend url passed to it.
+func NewHTTPHealthChecker(spec HTTPHealthCheck) (loadbalancer.HealthChecker, error) {
+	c := httpHealthChecker{
+		spec:    spec,
+		headers: make(http.Header, len(spec.Headers)),
+		lock:    new(sync.Mutex),
+		clients: make(map[string]*http.Client, 1),
+	}
+	if c.spec.Method == "" {
+		c.spec.Method = http.MethodGet
+	} else {
+		c.spec.Method = strings.ToUpper(c.spec.Method)
+	}
+
+	var err error
+	if c.spec.Path == "" {
+		c.spec.Path = "/"
+	}
+	if c.path, err = url.Parse(c.spec.Path); err != nil {
+		return nil, err
+	}
+
+	if len(c.spec.Status) == 0 {
+		c.status = []statusRange{{min: 200, max: 299}}
+	} else {
+		c.status = make([]statusRange, len(c.spec.Status))
+		for i, s := range c.spec.Status {
+			if c.status[i], err = parseStatusRange(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.spec.BodyRegexp != "" {
+		if c.regexp, err = regexp.Compile(c.spec.BodyRegexp); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range c.spec.Headers {
+		c.headers.Set(key, value)
+	}
+
+	// Check the tls options in advance.
+	if _, err = c.getClient(""); err != nil {
+		return nil, err
+	}
+
+	return c.Check, nil
+}
+
+// getClient returns the http client to request the host.
+func (c httpHealthChecker) getClient(host string) (*http.Client, error) {
+	if c.spec.TLS.IsZero() {
+		host = ""
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if client, ok := c.clients[host]; ok {
+		return client, nil
+	}
+
+	tlsconf, err := c.spec.TLS.TLSConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// The timeout is controlled by the context, and the connection is not
+	// reused in order to check the backend really.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsconf
+	transport.DisableKeepAlives = true
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	c.clients[host] = client
+	return client, nil
+}
+
+func (c httpHealthChecker) Check(ctx context.Context, backendURL string) error {
+	base, err := url.Parse(backendURL)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.spec.Method, base.ResolveReference(c.path).String(), nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.headers {
+		req.Header[key] = values
+	}
+	if host := c.headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	client, err := c.getClient(req.URL.Hostname())
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !c.matchStatus(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	} else if c.spec.Body == "" && c.regexp == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBodySize))
+	if err != nil {
+		return err
+	} else if c.spec.Body != "" && !strings.Contains(string(body), c.spec.Body) {
+		return fmt.Errorf("the response body does not contain '%s'", c.spec.Body)
+	} else if c.regexp != nil && !c.regexp.Match(body) {
+		return fmt.Errorf("the response body does not match '%s'", c.spec.BodyRegexp)
+	}
+	return nil
+}
+
+func (c httpHealthChecker) matchStatus(code int) bool {
+	for _, r := range c.status {
+		if r.min <= code && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		status string
+		expect statusRange
+		fail   bool
+	}{
+		{"200", statusRange{200, 200}, false},
+		{" 204 ", statusRange{204, 204}, false},
+		{"200-299", statusRange{200, 299}, false},
+		{"301 - 302", statusRange{301, 302}, false},
+		{"2xx", statusRange{200, 299}, false},
+		{"5XX", statusRange{500, 599}, false},
+		{"6xx", statusRange{}, true},
+		{"0xx", statusRange{}, true},
+		{"99", statusRange{}, true},
+		{"600", statusRange{}, true},
+		{"299-200", statusRange{}, true},
+		{"200-", statusRange{}, true},
+		{"-200", statusRange{}, true},
+		{"abc", statusRange{}, true},
+		{"", statusRange{}, true},
+	}
+
+	for _, tt := range tests {
+		r, err := parseStatusRange(tt.status)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("'%s': expect an error, but got nil", tt.status)
+			}
+		} else if err != nil {
+			t.Errorf("'%s': unexpected error: %v", tt.status, err)
+		} else if r != tt.expect {
+			t.Errorf("'%s': expect %+v, but got %+v", tt.status, tt.expect, r)
+		}
+	}
+}
+
+func TestHTTPHealthChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"ok","host":"` + r.Host + `"}`))
+		case "/redirect":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		spec HTTPHealthCheck
+		fail bool
+	}{
+		{"default path", HTTPHealthCheck{}, true},
+		{"path", HTTPHealthCheck{Path: "/health"}, false},
+		{"absolute url", HTTPHealthCheck{Path: server.URL + "/health"}, false},
+		{"not found", HTTPHealthCheck{Path: "/missing"}, true},
+		{"expected not found", HTTPHealthCheck{Path: "/missing", Status: []string{"404"}}, false},
+		{"redirect not followed", HTTPHealthCheck{Path: "/redirect"}, true},
+		{"redirect", HTTPHealthCheck{Path: "/redirect", Status: []string{"2xx", "300-399"}}, false},
+		{"body", HTTPHealthCheck{Path: "/health", Body: `"ok"`}, false},
+		{"unexpected body", HTTPHealthCheck{Path: "/health", Body: "fail"}, true},
+		{"body regexp", HTTPHealthCheck{Path: "/health", BodyRegexp: `"status":\s*"ok"`}, false},
+		{"unmatched body regexp", HTTPHealthCheck{Path: "/health", BodyRegexp: `^fail`}, true},
+		{"host header", HTTPHealthCheck{
+			Path:    "/health",
+			Headers: map[string]string{"Host": "www.example.com"},
+			Body:    "www.example.com",
+		}, false},
+	}
+
+	for _, tt := range tests {
+		check, err := NewHTTPHealthChecker(tt.spec)
+		if err != nil {
+			t.Errorf("%s: fail to create the health checker: %v", tt.name, err)
+			continue
+		}
+
+		err = check(context.Background(), server.URL)
+		if tt.fail && err == nil {
+			t.Errorf("%s: expect an error, but got nil", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+
+	for _, spec := range []HTTPHealthCheck{
+		{Status: []string{"2xx", "invalid"}},
+		{BodyRegexp: "("},
+	} {
+		if _, err := NewHTTPHealthChecker(spec); err == nil {
+			t.Errorf("%+v: expect an error, but got nil", spec)
+		}
+	}
+}
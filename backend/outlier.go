@@ -0,0 +1,290 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/ship/v3"
+)
+
+// Define the default settings of the outlier detection.
+const (
+	DefaultOutlierConsecutive5xx    = 5
+	DefaultOutlierBaseEjectionTime  = time.Second * 30
+	DefaultOutlierMaxEjectionTime   = time.Minute * 5
+	DefaultOutlierMaxEjectedPercent = 10
+)
+
+// OutlierConfig is the configuration of the passive outlier detection,
+// which ejects the backend from the route forwarder temporarily
+// by the failures of the live requests.
+//
+// The ejection time is BaseEjectionTime multiplied by 2^(N-1), where N is
+// the number of the consecutive ejections, and no longer than MaxEjectionTime.
+// N is reset if the backend has worked for MaxEjectionTime after the last
+// ejection.
+type OutlierConfig struct {
+	// Consecutive5xx is the number of the consecutive 5xx responses or
+	// connection errors to eject the backend, which is
+	// DefaultOutlierConsecutive5xx by default.
+	Consecutive5xx int `json:"consecutive_5xx,omitempty" validate:"min=0"`
+
+	// ConsecutiveErrors is the number of the consecutive connection errors
+	// to eject the backend, which is disabled if ZERO.
+	ConsecutiveErrors int `json:"consecutive_errors,omitempty" validate:"min=0"`
+
+	// BaseEjectionTime is DefaultOutlierBaseEjectionTime by default.
+	BaseEjectionTime string `json:"base_ejection_time,omitempty"`
+
+	// MaxEjectionTime is DefaultOutlierMaxEjectionTime by default.
+	MaxEjectionTime string `json:"max_ejection_time,omitempty"`
+
+	// MaxEjectedPercent is the maximum percent of the ejected backends of
+	// the route, which is DefaultOutlierMaxEjectedPercent by default.
+	// But one backend is always allowed to be ejected if there are two
+	// backends at least.
+	MaxEjectedPercent int `json:"max_ejected_percent,omitempty" validate:"min=0,max=100"`
+}
+
+type outlierDetector struct {
+	consecutive5xx    int32
+	consecutiveErrors int32
+	baseEjectionTime  time.Duration
+	maxEjectionTime   time.Duration
+	maxEjectedPercent int
+}
+
+func (c OutlierConfig) newOutlierDetector() (d *outlierDetector, err error) {
+	d = &outlierDetector{
+		consecutive5xx:    int32(c.Consecutive5xx),
+		consecutiveErrors: int32(c.ConsecutiveErrors),
+		baseEjectionTime:  DefaultOutlierBaseEjectionTime,
+		maxEjectionTime:   DefaultOutlierMaxEjectionTime,
+		maxEjectedPercent: c.MaxEjectedPercent,
+	}
+
+	if d.consecutive5xx < 0 || d.consecutiveErrors < 0 {
+		return nil, fmt.Errorf("the consecutive failures must not be negative")
+	} else if d.maxEjectedPercent < 0 || d.maxEjectedPercent > 100 {
+		return nil, fmt.Errorf("invalid max ejected percent '%d'", d.maxEjectedPercent)
+	}
+
+	if d.consecutive5xx == 0 {
+		d.consecutive5xx = DefaultOutlierConsecutive5xx
+	}
+	if d.maxEjectedPercent == 0 {
+		d.maxEjectedPercent = DefaultOutlierMaxEjectedPercent
+	}
+
+	if c.BaseEjectionTime != "" {
+		if d.baseEjectionTime, err = time.ParseDuration(c.BaseEjectionTime); err != nil {
+			return nil, err
+		} else if d.baseEjectionTime <= 0 {
+			return nil, fmt.Errorf("invalid base ejection time '%s'", c.BaseEjectionTime)
+		}
+	}
+	if c.MaxEjectionTime != "" {
+		if d.maxEjectionTime, err = time.ParseDuration(c.MaxEjectionTime); err != nil {
+			return nil, err
+		}
+	}
+	if d.maxEjectionTime < d.baseEjectionTime {
+		d.maxEjectionTime = d.baseEjectionTime
+	}
+
+	return
+}
+
+// ejectionTime returns the ejection time of the n-th consecutive ejection.
+func (d *outlierDetector) ejectionTime(n int) time.Duration {
+	timeout := d.baseEjectionTime
+	for i := 1; i < n && timeout < d.maxEjectionTime; i++ {
+		timeout *= 2
+	}
+	if timeout > d.maxEjectionTime {
+		timeout = d.maxEjectionTime
+	}
+	return timeout
+}
+
+// maxEjected returns the maximum number of the ejected backends.
+func (d *outlierDetector) maxEjected(total int) int {
+	max := total * d.maxEjectedPercent / 100
+	if max == 0 && total > 1 {
+		max = 1
+	}
+	return max
+}
+
+// outlierState is the state of the outlier detection of the backend.
+type outlierState struct {
+	failures  int32     // The consecutive 5xx responses or connection errors.
+	errors    int32     // The consecutive connection errors.
+	ejections int       // The consecutive ejections.
+	ejected   time.Time // The last time when the backend is ejected.
+	until     time.Time // The end of the ejection, which is ZERO if not.
+}
+
+// EjectionState is the state of the endpoint ejected by the outlier detection.
+type EjectionState struct {
+	Forwarder string    `json:"forwarder"`
+	Until     time.Time `json:"until"`
+	Ejections int       `json:"ejections"`
+}
+
+// GetEjectionStates returns the states of the endpoint ejected from the
+// forwarders by the outlier detection.
+func GetEjectionStates(addr string) (states []EjectionState) {
+	for _, f := range getForwarders(addr) {
+		if state, ok := f.ejectionState(addr); ok {
+			states = append(states, state)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Forwarder < states[j].Forwarder })
+	return
+}
+
+// isForwardFailure reports whether it fails to forward the request by the
+// error and the status code of the response, and whether it is caused by
+// the connection error.
+//
+// The client errors and the rejection of the circuit breaker are not failures.
+func isForwardFailure(ctx *apigw.Context, err error) (failed, connErr bool) {
+	if err != nil {
+		if he, ok := err.(ship.HTTPError); ok && (he.Code < 500 || he.Err == ErrCircuitOpen) {
+			return false, false
+		} else if !ctx.IsResponded() {
+			return true, true
+		}
+	}
+	return ctx.StatusCode() >= 500, false
+}
+
+// checkOutlier counts the result of the request forwarded to the endpoint,
+// and ejects it if it is an outlier.
+func (f *Forwarder) checkOutlier(d *outlierDetector, addr string,
+	ctx *apigw.Context, err error) {
+	failed, connErr := isForwardFailure(ctx, err)
+	if !failed && err != nil {
+		return
+	}
+
+	f.lock.RLock()
+	leaf, ok := f.leaves[addr]
+	f.lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	state := &leaf.outlier
+	if !failed {
+		atomic.StoreInt32(&state.failures, 0)
+		atomic.StoreInt32(&state.errors, 0)
+		return
+	}
+
+	eject := atomic.AddInt32(&state.failures, 1) >= d.consecutive5xx
+	if connErr {
+		n := atomic.AddInt32(&state.errors, 1)
+		eject = eject || (d.consecutiveErrors > 0 && n >= d.consecutiveErrors)
+	} else {
+		atomic.StoreInt32(&state.errors, 0)
+	}
+
+	if eject {
+		f.ejectBackend(d, addr)
+	}
+}
+
+// ejectBackend ejects the backend from the forwarder temporarily
+// if the maximum ejected percent is not exceeded.
+func (f *Forwarder) ejectBackend(d *outlierDetector, addr string) {
+	now := time.Now()
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	if !ok || !leaf.deadline.IsZero() || !leaf.outlier.until.IsZero() {
+		f.lock.Unlock()
+		return
+	}
+
+	var total, ejected int
+	for _, l := range f.leaves {
+		if l.deadline.IsZero() {
+			total++
+			if !l.outlier.until.IsZero() {
+				ejected++
+			}
+		}
+	}
+	if ejected >= d.maxEjected(total) {
+		f.lock.Unlock()
+		return
+	}
+
+	state := &leaf.outlier
+	if now.Sub(state.ejected) > d.maxEjectionTime {
+		state.ejections = 0
+	}
+	state.ejections++
+	state.ejected = now
+	state.until = now.Add(d.ejectionTime(state.ejections))
+	atomic.StoreInt32(&state.failures, 0)
+	atomic.StoreInt32(&state.errors, 0)
+	until, endpoint := state.until, leaf.endpoint
+	f.lock.Unlock()
+
+	if endpoint != nil {
+		f.provider.DelEndpoint(endpoint)
+	}
+	time.AfterFunc(time.Until(until), func() { f.unejectBackend(addr, until) })
+}
+
+// unejectBackend brings the ejected backend back after the ejection.
+func (f *Forwarder) unejectBackend(addr string, until time.Time) {
+	f.lock.Lock()
+	leaf, ok := f.leaves[addr]
+	ok = ok && leaf.outlier.until.Equal(until)
+	if ok {
+		leaf.outlier.until = time.Time{}
+		leaf.outlier.ejected = time.Now()
+	}
+	f.lock.Unlock()
+
+	if ok {
+		f.refreshEndpoint(addr)
+	}
+}
+
+func (f *Forwarder) ejectionState(addr string) (state EjectionState, ok bool) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	leaf, ok := f.leaves[addr]
+	if !ok || leaf.outlier.until.IsZero() {
+		return EjectionState{}, false
+	}
+
+	return EjectionState{
+		Forwarder: f.name,
+		Until:     leaf.outlier.until,
+		Ejections: leaf.outlier.ejections,
+	}, true
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		conf OutlierConfig
+		base time.Duration
+		max  time.Duration
+		fail bool
+	}{
+		{"default", OutlierConfig{}, DefaultOutlierBaseEjectionTime, DefaultOutlierMaxEjectionTime, false},
+		{"custom", OutlierConfig{BaseEjectionTime: "1s", MaxEjectionTime: "10s"}, time.Second, 10 * time.Second, false},
+		{"max less than base", OutlierConfig{BaseEjectionTime: "1m", MaxEjectionTime: "1s"}, time.Minute, time.Minute, false},
+		{"zero base", OutlierConfig{BaseEjectionTime: "0s"}, 0, 0, true},
+		{"invalid base", OutlierConfig{BaseEjectionTime: "abc"}, 0, 0, true},
+		{"invalid max", OutlierConfig{MaxEjectionTime: "abc"}, 0, 0, true},
+		{"negative failures", OutlierConfig{Consecutive5xx: -1}, 0, 0, true},
+		{"invalid percent", OutlierConfig{MaxEjectedPercent: 101}, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		d, err := tt.conf.newOutlierDetector()
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expect an error, but got nil", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if d.baseEjectionTime != tt.base || d.maxEjectionTime != tt.max {
+			t.Errorf("%s: expect the ejection time [%s, %s], but got [%s, %s]",
+				tt.name, tt.base, tt.max, d.baseEjectionTime, d.maxEjectionTime)
+		}
+	}
+}
+
+func TestOutlierEjectionTime(t *testing.T) {
+	d := &outlierDetector{baseEjectionTime: time.Second, maxEjectionTime: 10 * time.Second}
+	tests := []struct {
+		n       int
+		timeout time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if timeout := d.ejectionTime(tt.n); timeout != tt.timeout {
+			t.Errorf("%d: expect the ejection time %s, but got %s", tt.n, tt.timeout, timeout)
+		}
+	}
+}
+
+func TestOutlierMaxEjected(t *testing.T) {
+	tests := []struct {
+		percent int
+		total   int
+		max     int
+	}{
+		{10, 0, 0},
+		{10, 1, 0},
+		{10, 2, 1},
+		{10, 20, 2},
+		{50, 5, 2},
+		{100, 1, 1},
+		{100, 3, 3},
+	}
+
+	for _, tt := range tests {
+		d := &outlierDetector{maxEjectedPercent: tt.percent}
+		if max := d.maxEjected(tt.total); max != tt.max {
+			t.Errorf("%d%% of %d: expect %d, but got %d", tt.percent, tt.total, tt.max, max)
+		}
+	}
+}
+
+func TestForwarderEjectBackend(t *testing.T) {
+	f, err := NewForwarder("test", ForwarderConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range newTestEndpoints(1, 1, 1, 1) {
+		f.AddEndpoint(ep)
+	}
+
+	// The draining backend is neither counted nor ejected.
+	f.leaves["127.0.0.1:8004"].deadline = time.Now().Add(time.Hour)
+
+	d := &outlierDetector{
+		baseEjectionTime:  time.Hour,
+		maxEjectionTime:   time.Hour,
+		maxEjectedPercent: 50,
+	}
+
+	tests := []struct {
+		addr    string
+		ejected bool
+	}{
+		{"127.0.0.1:8001", true},
+		{"127.0.0.1:8001", true}, // Ejected already
+		{"127.0.0.1:8002", false},
+		{"127.0.0.1:8004", false},
+		{"127.0.0.1:8009", false}, // Not exist
+	}
+
+	for _, tt := range tests {
+		f.ejectBackend(d, tt.addr)
+		if _, ejected := f.ejectionState(tt.addr); ejected != tt.ejected {
+			t.Errorf("%s: expect ejected %v, but got %v", tt.addr, tt.ejected, ejected)
+		}
+	}
+
+	if _len := len(f.Endpoints()); _len != 3 {
+		t.Errorf("expect 3 endpoints left, but got %d", _len)
+	}
+
+	state, _ := f.ejectionState("127.0.0.1:8001")
+	f.unejectBackend("127.0.0.1:8001", state.Until)
+	if _, ejected := f.ejectionState("127.0.0.1:8001"); ejected {
+		t.Errorf("the backend is still ejected after the ejection")
+	}
+}
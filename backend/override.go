@@ -0,0 +1,99 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Define the administrative states of the endpoint.
+const (
+	// AdminStateUp forces the endpoint to be online even if it is unhealthy.
+	AdminStateUp = "up"
+
+	// AdminStateDown forces the endpoint to be offline even if it is healthy.
+	AdminStateDown = "down"
+)
+
+// overrides is the administrative states of the endpoints overriding
+// their health status, which is keyed by the address of the endpoint.
+//
+// They are independent of the health checker, so they survive the health
+// check cycles and the re-adding of the endpoints.
+var overrides = struct {
+	lock   sync.RWMutex
+	states map[string]string
+}{states: make(map[string]string)}
+
+// GetEndpointOverride returns the administrative state of the endpoint
+// by the address, which is "" if not overridden.
+func GetEndpointOverride(addr string) (state string) {
+	overrides.lock.RLock()
+	state = overrides.states[addr]
+	overrides.lock.RUnlock()
+	return
+}
+
+// GetEndpointOverrides returns the administrative states of all the
+// overridden endpoints.
+func GetEndpointOverrides() map[string]string {
+	overrides.lock.RLock()
+	states := make(map[string]string, len(overrides.states))
+	for addr, state := range overrides.states {
+		states[addr] = state
+	}
+	overrides.lock.RUnlock()
+	return states
+}
+
+// SetEndpointOverride marks the endpoint administratively up or down
+// by the address, which overrides its health status for all the route
+// forwarders and backend groups referring to it.
+//
+// If state is "", clear the override and restore the health status.
+func SetEndpointOverride(addr, state string) error {
+	switch state {
+	case "", AdminStateUp, AdminStateDown:
+	default:
+		return fmt.Errorf("unknown administrative state '%s'", state)
+	}
+
+	overrides.lock.Lock()
+	if state == "" {
+		delete(overrides.states, addr)
+	} else {
+		overrides.states[addr] = state
+	}
+	overrides.lock.Unlock()
+
+	for _, f := range getForwarders(addr) {
+		f.refreshEndpoint(addr)
+	}
+	return nil
+}
+
+// IsEndpointOnline reports whether the endpoint is online by the address,
+// that's, it is healthy and not administratively down, or administratively up.
+func IsEndpointOnline(addr string) bool {
+	switch GetEndpointOverride(addr) {
+	case AdminStateUp:
+		return true
+	case AdminStateDown:
+		return false
+	default:
+		return HC.IsHealthy(addr)
+	}
+}
@@ -0,0 +1,381 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/go-service/loadbalancer"
+	"github.com/xgfone/ship/v3"
+)
+
+// Define the default settings of the retry policy.
+const (
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryBaseBackoff = time.Millisecond * 25
+	DefaultRetryMaxBackoff  = time.Millisecond * 250
+	DefaultRetryMaxBodySize = 64 * 1024
+)
+
+// Define the retryable conditions.
+const (
+	RetryOnConnectError = "connect_error"
+	RetryOnTimeout      = "timeout"
+	RetryOn5xx          = "5xx"
+)
+
+// DefaultRetryOn is the default retryable conditions.
+var DefaultRetryOn = []string{RetryOnConnectError, "502", "503", "504"}
+
+// DefaultRetryMethods is the default methods to be retried,
+// which are idempotent.
+var DefaultRetryMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions,
+	http.MethodPut, http.MethodDelete, http.MethodTrace,
+}
+
+// RetryConfig is the configuration of the retry policy of the route,
+// which retries the failed request on another backend if possible.
+//
+// The backoff before the n-th retry is the random duration between ZERO and
+// BaseBackoff multiplied by 2^(n-1), which is no longer than MaxBackoff.
+type RetryConfig struct {
+	// RetryOn is the retryable conditions, which are "connect_error",
+	// "timeout", "5xx" or the status code such as "503".
+	// It is DefaultRetryOn by default.
+	RetryOn []string `json:"retry_on,omitempty"`
+
+	// MaxAttempts is the maximum number of the attempts including the first,
+	// which is DefaultRetryMaxAttempts by default.
+	MaxAttempts int `json:"max_attempts,omitempty" validate:"min=0"`
+
+	// PerTryTimeout is the timeout of each attempt, which is not limited
+	// by default. But all the attempts are limited by MaxTimeout of the route.
+	PerTryTimeout string `json:"per_try_timeout,omitempty"`
+
+	// BaseBackoff is DefaultRetryBaseBackoff by default.
+	BaseBackoff string `json:"base_backoff,omitempty"`
+
+	// MaxBackoff is DefaultRetryMaxBackoff by default.
+	MaxBackoff string `json:"max_backoff,omitempty"`
+
+	// Methods is the methods of the requests to be retried,
+	// which is DefaultRetryMethods by default.
+	Methods []string `json:"methods,omitempty"`
+
+	// MaxBodySize is the maximum size of the request body buffered to be
+	// replayed, which is DefaultRetryMaxBodySize by default. The request
+	// whose body is larger than it is not retried.
+	MaxBodySize int64 `json:"max_body_size,omitempty" validate:"min=0"`
+}
+
+// failoverPolicy is the retry policy of the route without the retry policy,
+// which only fails over all the requests to the other endpoints when failing
+// to dial the endpoint.
+//
+// Its maxAttempts is the number of the endpoints, which is set when used.
+var failoverPolicy = &retryPolicy{
+	onConnectError: true,
+	onDialOnly:     true,
+	maxBodySize:    DefaultRetryMaxBodySize,
+	methods: map[string]struct{}{
+		http.MethodGet: {}, http.MethodHead: {}, http.MethodPost: {},
+		http.MethodPut: {}, http.MethodPatch: {}, http.MethodDelete: {},
+		http.MethodConnect: {}, http.MethodOptions: {}, http.MethodTrace: {},
+	},
+}
+
+type retryPolicy struct {
+	onConnectError bool
+	onDialOnly     bool // Only retry the connect errors when failing to dial.
+	onTimeout      bool
+	statuses       map[int]struct{}
+	on5xx          bool
+
+	maxAttempts   int
+	perTryTimeout time.Duration
+	baseBackoff   time.Duration
+	maxBackoff    time.Duration
+	methods       map[string]struct{}
+	maxBodySize   int64
+}
+
+func (c RetryConfig) newRetryPolicy() (p *retryPolicy, err error) {
+	p = &retryPolicy{
+		statuses:    make(map[int]struct{}, 4),
+		maxAttempts: c.MaxAttempts,
+		baseBackoff: DefaultRetryBaseBackoff,
+		maxBackoff:  DefaultRetryMaxBackoff,
+		maxBodySize: c.MaxBodySize,
+	}
+
+	if p.maxAttempts < 0 {
+		return nil, fmt.Errorf("invalid max attempts '%d'", p.maxAttempts)
+	} else if p.maxAttempts == 0 {
+		p.maxAttempts = DefaultRetryMaxAttempts
+	}
+
+	if p.maxBodySize < 0 {
+		return nil, fmt.Errorf("invalid max body size '%d'", p.maxBodySize)
+	} else if p.maxBodySize == 0 {
+		p.maxBodySize = DefaultRetryMaxBodySize
+	}
+
+	retryOn := c.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = DefaultRetryOn
+	}
+	for _, on := range retryOn {
+		switch on {
+		case RetryOnConnectError:
+			p.onConnectError = true
+		case RetryOnTimeout:
+			p.onTimeout = true
+		case RetryOn5xx:
+			p.on5xx = true
+		default:
+			code, err := strconv.Atoi(on)
+			if err != nil || code < 100 || code > 599 {
+				return nil, fmt.Errorf("unknown retryable condition '%s'", on)
+			}
+			p.statuses[code] = struct{}{}
+		}
+	}
+
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = DefaultRetryMethods
+	}
+	p.methods = make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		p.methods[strings.ToUpper(method)] = struct{}{}
+	}
+
+	if c.PerTryTimeout != "" {
+		if p.perTryTimeout, err = time.ParseDuration(c.PerTryTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if c.BaseBackoff != "" {
+		if p.baseBackoff, err = time.ParseDuration(c.BaseBackoff); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxBackoff != "" {
+		if p.maxBackoff, err = time.ParseDuration(c.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+	if p.maxBackoff < p.baseBackoff {
+		p.maxBackoff = p.baseBackoff
+	}
+
+	return
+}
+
+func (p *retryPolicy) retryStatus(code int) bool {
+	if p.on5xx && code >= 500 {
+		return true
+	}
+	_, ok := p.statuses[code]
+	return ok
+}
+
+// backoff waits for the backoff before the n-th retry, and reports
+// whether it is not interrupted by the contexts.
+func (p *retryPolicy) backoff(c, rc context.Context, n int) bool {
+	max := p.baseBackoff
+	for i := 1; i < n && max < p.maxBackoff; i++ {
+		max *= 2
+	}
+	if max > p.maxBackoff {
+		max = p.maxBackoff
+	}
+	if max <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(max) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Done():
+		return false
+	case <-rc.Done():
+		return false
+	}
+}
+
+// bufferBody reads the request body into the memory to be replayed,
+// and reports whether the body is not larger than the maximum size.
+func (p *retryPolicy) bufferBody(r *http.Request) (body []byte, ok bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	} else if r.ContentLength > p.maxBodySize {
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, p.maxBodySize+1))
+	if err != nil || int64(len(body)) > p.maxBodySize {
+		// Give back the read data to forward the request only once.
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	return body, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// retryWriter is the response writer of an attempt, which discards
+// the response with the retryable status code.
+type retryWriter struct {
+	http.ResponseWriter
+	header    http.Header
+	retry     func(code int) bool // nil represents the last attempt.
+	discarded bool
+	wrote     bool
+}
+
+func (w *retryWriter) Header() http.Header { return w.header }
+
+func (w *retryWriter) WriteHeader(code int) {
+	if w.retry != nil && w.retry(code) {
+		w.discarded = true
+		return
+	}
+
+	header := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *retryWriter) Write(p []byte) (int, error) {
+	if w.discarded {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *retryWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); !w.discarded && ok {
+		flusher.Flush()
+	}
+}
+
+// forwardWithRetry forwards the request to the endpoint, and retries it
+// on another endpoint by the retry policy if it fails.
+func (f *Forwarder) forwardWithRetry(c context.Context, s *forwarderSettings,
+	p *retryPolicy, req forwardRequest, ep loadbalancer.Endpoint) (err error) {
+	ctx := req.ctx
+	if _, ok := p.methods[ctx.Method()]; !ok || p.maxAttempts < 2 {
+		return f.roundTrip(c, s, req, ep)
+	}
+
+	r := ctx.Request()
+	body, ok := p.bufferBody(r)
+	if !ok {
+		return f.roundTrip(c, s, req, ep)
+	}
+
+	res := ctx.Response()
+	w := res.ResponseWriter
+	header := w.Header().Clone()
+	defer res.SetWriter(w)
+
+	tried := make(map[string]struct{}, p.maxAttempts)
+	for attempt := 1; ; attempt++ {
+		rw := &retryWriter{ResponseWriter: w, header: header.Clone()}
+		if attempt < p.maxAttempts {
+			rw.retry = p.retryStatus
+		}
+		res.Reset(rw)
+
+		if attempt > 1 {
+			if ep = f.failover(req, f.provider.Select(req), tried); ep == nil {
+				return ship.ErrBadGateway.New(lb.ErrNoAvailableBackends)
+			} else if s.session != nil {
+				s.session.Set(ctx, ep.String())
+			}
+		}
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		tc, cancel := c, context.CancelFunc(func() {})
+		if p.perTryTimeout > 0 {
+			tc, cancel = context.WithTimeout(c, p.perTryTimeout)
+		}
+		err = f.roundTrip(tc, s, req, ep)
+		timeout := tc.Err() == context.DeadlineExceeded && c.Err() == nil
+		cancel()
+
+		if !p.shouldRetry(rw, err, timeout) {
+			return
+		}
+
+		tried[ep.String()] = struct{}{}
+		if !p.backoff(c, r.Context(), attempt) {
+			if err == nil {
+				err = ship.ErrBadGateway.Newf("the backend responded %d", ctx.StatusCode())
+			}
+			return
+		}
+	}
+}
+
+// shouldRetry reports whether the attempt should be retried.
+func (p *retryPolicy) shouldRetry(w *retryWriter, err error, timeout bool) bool {
+	switch {
+	case w.discarded:
+		return true
+	case err == nil, w.retry == nil, w.wrote:
+		return false
+	}
+
+	if he, ok := err.(ship.HTTPError); ok && (he.Code < 500 || he.Err == ErrCircuitOpen) {
+		return false
+	} else if timeout {
+		return p.onTimeout
+	} else if p.onDialOnly {
+		return p.onConnectError && isDialError(err)
+	}
+	return p.onConnectError
+}
+
+// isDialError reports whether the error is caused by failing to dial.
+func isDialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xgfone/ship/v3"
+)
+
+func TestRetryConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     RetryConfig
+		attempts int
+		statuses []int
+		fail     bool
+	}{
+		{"default", RetryConfig{}, DefaultRetryMaxAttempts, []int{502, 503, 504}, false},
+		{"custom", RetryConfig{MaxAttempts: 5, RetryOn: []string{"5xx", "429"}}, 5, []int{429, 500, 599}, false},
+		{"negative attempts", RetryConfig{MaxAttempts: -1}, 0, nil, true},
+		{"negative body size", RetryConfig{MaxBodySize: -1}, 0, nil, true},
+		{"unknown condition", RetryConfig{RetryOn: []string{"unknown"}}, 0, nil, true},
+		{"invalid status", RetryConfig{RetryOn: []string{"600"}}, 0, nil, true},
+		{"invalid timeout", RetryConfig{PerTryTimeout: "abc"}, 0, nil, true},
+		{"invalid backoff", RetryConfig{BaseBackoff: "abc"}, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		p, err := tt.conf.newRetryPolicy()
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expect an error, but got nil", tt.name)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if p.maxAttempts != tt.attempts {
+			t.Errorf("%s: expect %d attempts, but got %d", tt.name, tt.attempts, p.maxAttempts)
+		}
+		for _, code := range tt.statuses {
+			if !p.retryStatus(code) {
+				t.Errorf("%s: expect to retry the status %d", tt.name, code)
+			}
+		}
+		if p.retryStatus(http.StatusOK) || p.retryStatus(http.StatusNotFound) {
+			t.Errorf("%s: expect not to retry the status 200 and 404", tt.name)
+		}
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	retry, err := RetryConfig{RetryOn: []string{RetryOnConnectError, RetryOnTimeout}}.newRetryPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+	retryStatus := func(int) bool { return true }
+
+	tests := []struct {
+		name    string
+		policy  *retryPolicy
+		writer  retryWriter
+		err     error
+		timeout bool
+		retry   bool
+	}{
+		{"discarded", retry, retryWriter{retry: retryStatus, discarded: true}, nil, false, true},
+		{"success", retry, retryWriter{retry: retryStatus}, nil, false, false},
+		{"last attempt", retry, retryWriter{}, dialErr, false, false},
+		{"responded", retry, retryWriter{retry: retryStatus, wrote: true}, readErr, false, false},
+		{"dial error", retry, retryWriter{retry: retryStatus}, ship.ErrBadGateway.New(dialErr), false, true},
+		{"read error", retry, retryWriter{retry: retryStatus}, ship.ErrBadGateway.New(readErr), false, true},
+		{"timeout", retry, retryWriter{retry: retryStatus}, context.DeadlineExceeded, true, true},
+		{"client error", retry, retryWriter{retry: retryStatus}, ship.ErrBadRequest, false, false},
+		{"circuit open", retry, retryWriter{retry: retryStatus},
+			ship.ErrServiceUnavailable.New(ErrCircuitOpen), false, false},
+
+		{"failover dial error", failoverPolicy, retryWriter{retry: retryStatus},
+			ship.ErrBadGateway.New(dialErr), false, true},
+		{"failover read error", failoverPolicy, retryWriter{retry: retryStatus},
+			ship.ErrBadGateway.New(readErr), false, false},
+		{"failover timeout", failoverPolicy, retryWriter{retry: retryStatus},
+			context.DeadlineExceeded, true, false},
+	}
+
+	for _, tt := range tests {
+		if retry := tt.policy.shouldRetry(&tt.writer, tt.err, tt.timeout); retry != tt.retry {
+			t.Errorf("%s: expect retry %v, but got %v", tt.name, tt.retry, retry)
+		}
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = http.Get("http://" + addr)
+	if err == nil {
+		t.Fatal("expect an error to connect to the closed port")
+	} else if !isDialError(err) {
+		t.Errorf("expect a dial error, but got '%v'", err)
+	}
+
+	if isDialError(errors.New("error")) || isDialError(context.Canceled) {
+		t.Errorf("the non-dial error is regarded as the dial error")
+	}
+}
+
+func TestRetryPolicyBufferBody(t *testing.T) {
+	p := &retryPolicy{maxBodySize: 8}
+	tests := []struct {
+		name   string
+		body   string
+		length int64
+		ok     bool
+	}{
+		{"no body", "", 0, true},
+		{"small body", "abc", 3, true},
+		{"max body", "12345678", 8, true},
+		{"too large content length", "123456789", 9, false},
+		{"too large chunked body", "123456789", -1, false},
+	}
+
+	for _, tt := range tests {
+		var req *http.Request
+		if tt.body == "" {
+			req = httptest.NewRequest(http.MethodPost, "/", nil)
+		} else {
+			req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.ContentLength = tt.length
+		}
+
+		body, ok := p.bufferBody(req)
+		if ok != tt.ok {
+			t.Errorf("%s: expect ok %v, but got %v", tt.name, tt.ok, ok)
+		} else if ok && string(body) != tt.body {
+			t.Errorf("%s: expect the body '%s', but got '%s'", tt.name, tt.body, body)
+		}
+
+		// The body not buffered is still forwarded completely.
+		if !ok {
+			if data, _ := ioutil.ReadAll(req.Body); string(data) != tt.body {
+				t.Errorf("%s: expect the remaining body '%s', but got '%s'", tt.name, tt.body, data)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		policy *retryPolicy
+		ctx    context.Context
+		ok     bool
+	}{
+		{"no backoff", &retryPolicy{}, canceled, true},
+		{"backoff", &retryPolicy{baseBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+			context.Background(), true},
+		{"canceled", &retryPolicy{baseBackoff: time.Hour, maxBackoff: time.Hour}, canceled, false},
+	}
+
+	for _, tt := range tests {
+		if ok := tt.policy.backoff(tt.ctx, context.Background(), 3); ok != tt.ok {
+			t.Errorf("%s: expect %v, but got %v", tt.name, tt.ok, ok)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// DefaultPolicy is the default load-balancing policy of the forwarder.
+const DefaultPolicy = "round_robin"
+
+// PolicyConfig is the configuration of the load-balancing policy.
+type PolicyConfig struct {
+	// Policy is the name of the load-balancing policy, such as
+	//
+	//   "round_robin":            Select the endpoint in turn, which is the default.
+	//   "weighted_round_robin":   Select the endpoint in turn by the weight smoothly.
+	//   "random":                 Select the endpoint randomly.
+	//   "source_ip":              Select the endpoint by the remote address.
+	//   "weight":                 Select the endpoint randomly by the weight.
+	//   "least_conn":             Select the endpoint with the least in-flight requests.
+	//   "least_latency":          Select the endpoint with the least EWMA latency.
+	//   "consistent_hash_ip":     Select the endpoint by hashing the client ip.
+	//   "consistent_hash_header": Select the endpoint by hashing the header HashKey.
+	//   "consistent_hash_cookie": Select the endpoint by hashing the cookie HashKey.
+	Policy string `json:"policy,omitempty"`
+
+	// HashKey is the name of the header or cookie to be hashed, which is only
+	// used by the policies "consistent_hash_header" and "consistent_hash_cookie".
+	HashKey string `json:"hash_key,omitempty"`
+}
+
+var selectors = make(map[string]func(PolicyConfig) (loadbalancer.Selector, error), 16)
+
+func init() {
+	registerSelector := func(name string, new func() loadbalancer.Selector) {
+		RegisterSelector(name, func(PolicyConfig) (loadbalancer.Selector, error) {
+			return new(), nil
+		})
+	}
+
+	registerSelector("random", loadbalancer.RandomSelector)
+	registerSelector("round_robin", loadbalancer.RoundRobinSelector)
+	registerSelector("source_ip", loadbalancer.SourceIPSelector)
+	registerSelector("weight", loadbalancer.WeightSelector)
+	registerSelector("weighted_round_robin", WeightedRoundRobinSelector)
+	registerSelector("least_conn", LeastConnSelector)
+	registerSelector("least_latency", LeastLatencySelector)
+	registerSelector("consistent_hash_ip", func() loadbalancer.Selector {
+		return ConsistentHashSelector("consistent_hash_ip", func(c *apigw.Context) string {
+			return c.RealIP()
+		})
+	})
+
+	RegisterSelector("consistent_hash_header", func(c PolicyConfig) (loadbalancer.Selector, error) {
+		if c.HashKey == "" {
+			return nil, fmt.Errorf("missing the hash key of the policy '%s'", c.Policy)
+		}
+		return ConsistentHashSelector(c.Policy, func(ctx *apigw.Context) string {
+			return ctx.GetHeader(c.HashKey)
+		}), nil
+	})
+
+	RegisterSelector("consistent_hash_cookie", func(c PolicyConfig) (loadbalancer.Selector, error) {
+		if c.HashKey == "" {
+			return nil, fmt.Errorf("missing the hash key of the policy '%s'", c.Policy)
+		}
+		return ConsistentHashSelector(c.Policy, func(ctx *apigw.Context) string {
+			if cookie := ctx.Cookie(c.HashKey); cookie != nil {
+				return cookie.Value
+			}
+			return ""
+		}), nil
+	})
+}
+
+// RegisterSelector registers the builder of the load-balancing selector
+// as the policy named name, which is called for each forwarder since
+// the selector may be stateful.
+//
+// If the policy has been registered, it will panic.
+func RegisterSelector(name string, new func(PolicyConfig) (loadbalancer.Selector, error)) {
+	if name == "" {
+		panic("the policy name must not be empty")
+	} else if new == nil {
+		panic("the selector builder must not be nil")
+	} else if _, ok := selectors[name]; ok {
+		panic(fmt.Errorf("the policy named '%s' has been registered", name))
+	}
+	selectors[name] = new
+}
+
+// UnregisterSelector unregisters the load-balancing selector by the policy name.
+func UnregisterSelector(name string) { delete(selectors, name) }
+
+// GetPolicies returns the names of all the registered load-balancing policies.
+func GetPolicies() []string {
+	policies := make([]string, 0, len(selectors))
+	for name := range selectors {
+		policies = append(policies, name)
+	}
+	sort.Strings(policies)
+	return policies
+}
+
+// NewSelector returns a new load-balancing selector by the policy configuration.
+//
+// If the policy is empty, it is DefaultPolicy.
+func NewSelector(conf PolicyConfig) (loadbalancer.Selector, error) {
+	if conf.Policy == "" {
+		conf.Policy = DefaultPolicy
+	}
+
+	if new, ok := selectors[conf.Policy]; ok {
+		return new(conf)
+	}
+	return nil, fmt.Errorf("no the load-balancing policy '%s'", conf.Policy)
+}
+
+// getWeight returns the weight of the endpoint, which is 1 by default
+// if the endpoint is not loadbalancer.WeightEndpoint.
+func getWeight(ep loadbalancer.Endpoint) int {
+	if we, ok := ep.(loadbalancer.WeightEndpoint); ok {
+		return we.Weight()
+	}
+	return 1
+}
+
+// WeightedRoundRobinSelector returns a smooth weighted round-robin selector,
+// whose name is "weighted_round_robin".
+//
+// If the weights of all the endpoints are ZERO, they are selected in turn.
+func WeightedRoundRobinSelector() loadbalancer.Selector {
+	var lock sync.Mutex
+	currents := make(map[string]int, 8)
+	return loadbalancer.SelectorFunc("weighted_round_robin",
+		func(req loadbalancer.Request, eps loadbalancer.Endpoints) loadbalancer.Endpoint {
+			lock.Lock()
+			defer lock.Unlock()
+
+			// Clean the states of the removed endpoints.
+			if len(currents) > len(eps)*2 {
+				currents = make(map[string]int, len(eps))
+			}
+
+			var total int
+			var selected loadbalancer.Endpoint
+			var selectedKey string
+			for _, ep := range eps {
+				weight := getWeight(ep)
+				if weight <= 0 {
+					continue
+				}
+
+				key := ep.String()
+				current := currents[key] + weight
+				currents[key] = current
+				total += weight
+
+				if selected == nil || current > currents[selectedKey] {
+					selected, selectedKey = ep, key
+				}
+			}
+
+			if selected == nil {
+				total = len(eps)
+				for _, ep := range eps {
+					key := ep.String()
+					current := currents[key] + 1
+					currents[key] = current
+					if selected == nil || current > currents[selectedKey] {
+						selected, selectedKey = ep, key
+					}
+				}
+			}
+
+			currents[selectedKey] -= total
+			return selected
+		})
+}
+
+// LeastConnSelector returns a selector to select the endpoint with the least
+// in-flight requests relative to its weight, whose name is "least_conn".
+//
+// The endpoint whose weight is ZERO is selected only if the weights of all
+// the endpoints are ZERO.
+//
+// The endpoint should implement the interface { Inflight() int64 },
+// or its in-flight requests are regarded as ZERO.
+func LeastConnSelector() loadbalancer.Selector {
+	var start uint64
+	return loadbalancer.SelectorFunc("least_conn",
+		func(req loadbalancer.Request, eps loadbalancer.Endpoints) loadbalancer.Endpoint {
+			// Start from the different endpoint to break the ties.
+			_len := len(eps)
+			offset := int(atomic.AddUint64(&start, 1) % uint64(_len))
+
+			var selected loadbalancer.Endpoint
+			var minScore float64
+			for i := 0; i < _len; i++ {
+				ep := eps[(offset+i)%_len]
+				score := weightedScore(float64(getInflight(ep)+1), ep)
+				if selected == nil || score < minScore {
+					selected, minScore = ep, score
+				}
+			}
+			return selected
+		})
+}
+
+// LeastLatencySelector returns a selector to select the endpoint with the
+// least EWMA latency multiplied by its in-flight requests plus one
+// relative to its weight, whose name is "least_latency".
+//
+// The endpoint should implement the interface { Latency() time.Duration },
+// or it degenerates to LeastConnSelector. The endpoint without any latency
+// is selected first in order to measure it.
+func LeastLatencySelector() loadbalancer.Selector {
+	var start uint64
+	return loadbalancer.SelectorFunc("least_latency",
+		func(req loadbalancer.Request, eps loadbalancer.Endpoints) loadbalancer.Endpoint {
+			_len := len(eps)
+			offset := int(atomic.AddUint64(&start, 1) % uint64(_len))
+
+			var selected loadbalancer.Endpoint
+			var minScore float64
+			for i := 0; i < _len; i++ {
+				ep := eps[(offset+i)%_len]
+				score := float64(getLatency(ep)+1) * float64(getInflight(ep)+1)
+				score = weightedScore(score, ep)
+				if selected == nil || score < minScore {
+					selected, minScore = ep, score
+				}
+			}
+			return selected
+		})
+}
+
+// ConsistentHashSelector returns a consistent hashing selector based on
+// the rendezvous hashing, which hashes the key returned by getKey,
+// so only the requests of the removed endpoint are moved to the others.
+// The weight of the endpoint is also respected.
+//
+// If the key is empty, it degenerates to the round-robin selector.
+func ConsistentHashSelector(name string, getKey func(*apigw.Context) string) loadbalancer.Selector {
+	rr := loadbalancer.RoundRobinSelector()
+	return loadbalancer.SelectorFunc(name,
+		func(req loadbalancer.Request, eps loadbalancer.Endpoints) loadbalancer.Endpoint {
+			var key string
+			if r, ok := req.(interface{ Context() *apigw.Context }); ok {
+				key = getKey(r.Context())
+			}
+			if key == "" {
+				return rr.Select(req, eps)
+			}
+
+			keyHash := hashString(key)
+			var selected loadbalancer.Endpoint
+			var maxScore float64
+			for _, ep := range eps {
+				weight := getWeight(ep)
+				if weight <= 0 {
+					continue
+				}
+
+				// Map the hash to (0, 1) to compute the weighted score.
+				h := mixHash(keyHash ^ hashString(ep.String()))
+				u := (float64(h>>11) + 0.5) / (1 << 53)
+				score := -float64(weight) / math.Log(u)
+				if selected == nil || score > maxScore {
+					selected, maxScore = ep, score
+				}
+			}
+
+			if selected == nil {
+				return eps[keyHash%uint64(len(eps))]
+			}
+			return selected
+		})
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mixHash is the finalizer of SplitMix64 to spread the bits of the hash.
+func mixHash(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// weightedScore returns the score divided by the weight of the endpoint,
+// which is the maximum if the weight is ZERO.
+func weightedScore(score float64, ep loadbalancer.Endpoint) float64 {
+	if weight := getWeight(ep); weight > 0 {
+		return score / float64(weight)
+	}
+	return math.MaxFloat64
+}
+
+func getInflight(ep loadbalancer.Endpoint) int64 {
+	if e, ok := ep.(interface{ Inflight() int64 }); ok {
+		return e.Inflight()
+	}
+	return 0
+}
+
+func getLatency(ep loadbalancer.Endpoint) time.Duration {
+	if e, ok := ep.(interface{ Latency() time.Duration }); ok {
+		return e.Latency()
+	}
+	return 0
+}
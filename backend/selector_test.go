@@ -0,0 +1,208 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+type testEndpoint struct {
+	loadbalancer.Endpoint
+	addr     string
+	weight   int
+	inflight int64
+	latency  time.Duration
+}
+
+func (e testEndpoint) String() string         { return e.addr }
+func (e testEndpoint) Weight() int            { return e.weight }
+func (e testEndpoint) Inflight() int64        { return e.inflight }
+func (e testEndpoint) Latency() time.Duration { return e.latency }
+
+type testRequest struct{}
+
+func (r testRequest) RemoteAddrString() string { return "127.0.0.1:12345" }
+func (r testRequest) Context() *apigw.Context  { return nil }
+
+func newTestEndpoints(weights ...int) loadbalancer.Endpoints {
+	eps := make(loadbalancer.Endpoints, len(weights))
+	for i, weight := range weights {
+		eps[i] = testEndpoint{addr: fmt.Sprintf("127.0.0.1:%d", 8001+i), weight: weight}
+	}
+	return eps
+}
+
+// countSelections selects the endpoints num times and returns the number
+// of the selections of each endpoint.
+func countSelections(s loadbalancer.Selector, eps loadbalancer.Endpoints, num int) []int {
+	counts := make([]int, len(eps))
+	for i := 0; i < num; i++ {
+		ep := s.Select(testRequest{}, eps)
+		for j := range eps {
+			if eps[j].String() == ep.String() {
+				counts[j]++
+			}
+		}
+	}
+	return counts
+}
+
+func TestSelectors(t *testing.T) {
+	fixedKey := func(*apigw.Context) string { return "key" }
+	tests := []struct {
+		name     string
+		selector loadbalancer.Selector
+		eps      loadbalancer.Endpoints
+		counts   []int
+	}{
+		{
+			name:     "weighted round robin",
+			selector: WeightedRoundRobinSelector(),
+			eps:      newTestEndpoints(3, 1, 0),
+			counts:   []int{6, 2, 0},
+		},
+		{
+			name:     "weighted round robin with zero weights",
+			selector: WeightedRoundRobinSelector(),
+			eps:      newTestEndpoints(0, 0),
+			counts:   []int{4, 4},
+		},
+		{
+			name:     "least conn",
+			selector: LeastConnSelector(),
+			eps: loadbalancer.Endpoints{
+				testEndpoint{addr: "127.0.0.1:8001", weight: 1, inflight: 2},
+				testEndpoint{addr: "127.0.0.1:8002", weight: 1, inflight: 0},
+				testEndpoint{addr: "127.0.0.1:8003", weight: 1, inflight: 1},
+			},
+			counts: []int{0, 8, 0},
+		},
+		{
+			name:     "least conn with weight",
+			selector: LeastConnSelector(),
+			eps: loadbalancer.Endpoints{
+				testEndpoint{addr: "127.0.0.1:8001", weight: 4, inflight: 2},
+				testEndpoint{addr: "127.0.0.1:8002", weight: 1, inflight: 1},
+				testEndpoint{addr: "127.0.0.1:8003", weight: 0, inflight: 0},
+			},
+			counts: []int{8, 0, 0},
+		},
+		{
+			name:     "least latency",
+			selector: LeastLatencySelector(),
+			eps: loadbalancer.Endpoints{
+				testEndpoint{addr: "127.0.0.1:8001", weight: 1, latency: 10 * time.Millisecond},
+				testEndpoint{addr: "127.0.0.1:8002", weight: 1, latency: time.Millisecond},
+				testEndpoint{addr: "127.0.0.1:8003", weight: 1, latency: 5 * time.Millisecond},
+			},
+			counts: []int{0, 8, 0},
+		},
+		{
+			name:     "least latency without weight",
+			selector: LeastLatencySelector(),
+			eps: loadbalancer.Endpoints{
+				testEndpoint{addr: "127.0.0.1:8001", weight: 1, latency: 10 * time.Millisecond},
+				testEndpoint{addr: "127.0.0.1:8002", weight: 0, latency: time.Millisecond},
+			},
+			counts: []int{8, 0},
+		},
+		{
+			name:     "consistent hash without key",
+			selector: ConsistentHashSelector("hash", func(*apigw.Context) string { return "" }),
+			eps:      newTestEndpoints(1, 1),
+			counts:   []int{4, 4},
+		},
+		{
+			name:     "consistent hash with zero weight",
+			selector: ConsistentHashSelector("hash", fixedKey),
+			eps:      newTestEndpoints(0, 1, 0),
+			counts:   []int{0, 8, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		if counts := countSelections(tt.selector, tt.eps, 8); !reflect.DeepEqual(counts, tt.counts) {
+			t.Errorf("%s: expect the selections %v, but got %v", tt.name, tt.counts, counts)
+		}
+	}
+}
+
+func TestConsistentHashSelector(t *testing.T) {
+	eps := newTestEndpoints(1, 1, 1, 1, 1)
+	for i := 0; i < 16; i++ {
+		key := fmt.Sprintf("key%d", i)
+		s := ConsistentHashSelector("hash", func(*apigw.Context) string { return key })
+		selected := s.Select(testRequest{}, eps).String()
+
+		// Only the requests of the removed endpoint are moved.
+		for j := range eps {
+			if eps[j].String() == selected {
+				continue
+			}
+
+			others := append(append(loadbalancer.Endpoints{}, eps[:j]...), eps[j+1:]...)
+			if ep := s.Select(testRequest{}, others).String(); ep != selected {
+				t.Errorf("%s: expect '%s' after removing '%s', but got '%s'",
+					key, selected, eps[j].String(), ep)
+			}
+		}
+	}
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := []struct {
+		conf PolicyConfig
+		name string
+		fail bool
+	}{
+		{PolicyConfig{}, DefaultPolicy, false},
+		{PolicyConfig{Policy: "least_conn"}, "least_conn", false},
+		{PolicyConfig{Policy: "consistent_hash_header", HashKey: "X-Key"}, "consistent_hash_header", false},
+		{PolicyConfig{Policy: "consistent_hash_header"}, "", true},
+		{PolicyConfig{Policy: "consistent_hash_cookie"}, "", true},
+		{PolicyConfig{Policy: "unknown"}, "", true},
+	}
+
+	for _, tt := range tests {
+		s, err := NewSelector(tt.conf)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%+v: expect an error, but got nil", tt.conf)
+			}
+		} else if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt.conf, err)
+		} else if s.String() != tt.name {
+			t.Errorf("%+v: expect the selector '%s', but got '%s'", tt.conf, tt.name, s.String())
+		}
+	}
+}
+
+func TestRegisterSelectorDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect a panic for the duplicate policy, but got nil")
+		}
+	}()
+
+	RegisterSelector(DefaultPolicy, func(PolicyConfig) (loadbalancer.Selector, error) {
+		return loadbalancer.RoundRobinSelector(), nil
+	})
+}
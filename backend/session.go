@@ -0,0 +1,404 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xgfone/apigw"
+)
+
+// Define the default settings of the session stickiness.
+const (
+	DefaultSessionCookieName = "apigw_session"
+	DefaultSessionMaxSize    = 10000
+	DefaultSessionTTL        = time.Hour
+)
+
+// MaskedSessionSecret is the masked value of the inline session secret,
+// which is returned by the admin api instead of the secret.
+//
+// If it is given back when updating the configuration, the current secret
+// of the route is kept.
+const MaskedSessionSecret = "******"
+
+// SessionConfig is the configuration of the session stickiness.
+type SessionConfig struct {
+	// Type is the type of the session stickiness, which is one of
+	//
+	//   "none":          Disable the session stickiness.
+	//   "remote_addr":   Use the remote address of the connection as the
+	//                    session id, which is the default.
+	//   "client_ip":     Use the real ip of the client as the session id,
+	//                    which also supports the headers "X-Forwarded-For"
+	//                    and "X-Real-IP".
+	//   "header":        Use the value of the header Name as the session id.
+	//   "cookie":        Use the value of the cookie Name as the session id.
+	//   "signed_cookie": Issue the cookie Name signed by Secret, which carries
+	//                    an opaque id of the backend instead of its address,
+	//                    so it does not depend on the memory and can be
+	//                    shared by the gateway replicas with the same Secret.
+	//
+	// Except "none" and "signed_cookie", the session ids are stored
+	// in the memory, which is bounded by MaxSize and TTL.
+	Type string `json:"type" validate:"zero|oneof=none remote_addr client_ip header cookie signed_cookie"`
+
+	// Name is the name of the header or cookie. For "signed_cookie",
+	// it is DefaultSessionCookieName by default.
+	Name string `json:"name,omitempty"`
+
+	// Secret is the secret key to sign the cookie. "signed_cookie" requires
+	// one of Secret, SecretEnv and SecretFile, which are tried in turn.
+	//
+	// Secret is write-only, which is masked as MaskedSessionSecret by the
+	// admin api, but it is still kept in the persisted configuration.
+	// So prefer SecretEnv or SecretFile to reference the secret instead.
+	Secret string `json:"secret,omitempty"`
+
+	// SecretEnv is the name of the environment variable of the secret.
+	SecretEnv string `json:"secret_env,omitempty"`
+
+	// SecretFile is the path of the file containing the secret, whose
+	// leading and trailing whitespaces are trimmed.
+	SecretFile string `json:"secret_file,omitempty"`
+
+	// TTL is the idle time to live of the session, which is
+	// DefaultSessionTTL by default. For "signed_cookie", it is the maximum
+	// age of the cookie.
+	TTL string `json:"ttl,omitempty"`
+
+	// MaxSize is the maximum number of the sessions stored in the memory,
+	// which is DefaultSessionMaxSize by default. If the number is exceeded,
+	// the least recently used session is evicted.
+	MaxSize int `json:"max_size,omitempty" validate:"min=0"`
+
+	// Secure and SameSite are the attributes of the cookie issued by
+	// "signed_cookie". SameSite is one of "lax", "strict" and "none",
+	// and "none" requires Secure.
+	Secure   bool   `json:"secure,omitempty"`
+	SameSite string `json:"same_site,omitempty" validate:"zero|oneof=lax strict none"`
+}
+
+var sameSites = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// sessionAffinity binds the requests with the same session id to the same
+// endpoint address.
+type sessionAffinity struct {
+	conf  SessionConfig
+	ttl   time.Duration
+	getID func(*apigw.Context) string
+
+	// For the memory store
+	store *sessionStore
+
+	// For the signed cookie
+	secret   []byte
+	sameSite http.SameSite
+	idLock   sync.RWMutex
+	ids      map[string]string // The map from the opaque id to the address.
+}
+
+// getSecret returns the secret of the signed session cookie.
+func (c SessionConfig) getSecret() (secret string, err error) {
+	switch {
+	case c.Secret == MaskedSessionSecret:
+		return "", errors.New("the session secret is masked, but no secret to be kept")
+	case c.Secret != "":
+		secret = c.Secret
+	case c.SecretEnv != "":
+		if secret = os.Getenv(c.SecretEnv); secret == "" {
+			return "", fmt.Errorf("no session secret in the environment variable '%s'", c.SecretEnv)
+		}
+	case c.SecretFile != "":
+		var data []byte
+		if data, err = ioutil.ReadFile(c.SecretFile); err != nil {
+			return "", fmt.Errorf("fail to read the session secret file: %v", err)
+		} else if secret = strings.TrimSpace(string(data)); secret == "" {
+			return "", fmt.Errorf("no session secret in the file '%s'", c.SecretFile)
+		}
+	default:
+		return "", errors.New("missing the secret of the signed session cookie")
+	}
+	return
+}
+
+func (c SessionConfig) newSessionAffinity() (s *sessionAffinity, err error) {
+	if c.Type == "none" {
+		return nil, nil
+	}
+
+	s = &sessionAffinity{conf: c, ttl: DefaultSessionTTL}
+	if c.TTL != "" {
+		if s.ttl, err = time.ParseDuration(c.TTL); err != nil {
+			return nil, err
+		} else if s.ttl < 0 {
+			return nil, fmt.Errorf("invalid session ttl '%s'", c.TTL)
+		}
+	}
+
+	switch c.Type {
+	case "", "remote_addr":
+		s.getID = func(c *apigw.Context) string { return c.RemoteAddr() }
+	case "client_ip":
+		s.getID = func(c *apigw.Context) string { return c.RealIP() }
+	case "header":
+		if c.Name == "" {
+			return nil, errors.New("missing the header name of the session")
+		}
+		s.getID = func(ctx *apigw.Context) string { return ctx.GetHeader(c.Name) }
+	case "cookie":
+		if c.Name == "" {
+			return nil, errors.New("missing the cookie name of the session")
+		}
+		s.getID = func(ctx *apigw.Context) string { return getCookie(ctx, c.Name) }
+	case "signed_cookie":
+		var secret string
+		if secret, err = c.getSecret(); err != nil {
+			return nil, err
+		}
+
+		sameSite, ok := sameSites[c.SameSite]
+		if !ok {
+			return nil, fmt.Errorf("invalid session cookie same site '%s'", c.SameSite)
+		} else if sameSite == http.SameSiteNoneMode && !c.Secure {
+			return nil, errors.New("the session cookie with same site 'none' must be secure")
+		}
+		if s.conf.Name == "" {
+			s.conf.Name = DefaultSessionCookieName
+		}
+		s.secret, s.sameSite = []byte(secret), sameSite
+		s.ids = make(map[string]string, 16)
+		s.getID = func(ctx *apigw.Context) string { return getCookie(ctx, s.conf.Name) }
+		return
+	default:
+		return nil, fmt.Errorf("unknown session type '%s'", c.Type)
+	}
+
+	maxsize := c.MaxSize
+	if maxsize == 0 {
+		maxsize = DefaultSessionMaxSize
+	}
+	s.store = newSessionStore(maxsize, s.ttl)
+	return
+}
+
+func getCookie(ctx *apigw.Context, name string) string {
+	if cookie := ctx.Cookie(name); cookie != nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// Get returns the endpoint address bound to the session of the request.
+// For the signed cookie, the opaque id of the endpoint is resolved
+// by the addresses returned by addrs.
+//
+// Return "" if the request has no session or the session has expired.
+func (s *sessionAffinity) Get(ctx *apigw.Context, addrs func() []string) (addr string) {
+	id := s.getID(ctx)
+	if id == "" {
+		return ""
+	} else if s.store != nil {
+		return s.store.Get(id)
+	} else if id = s.verify(id); id == "" {
+		return ""
+	}
+
+	s.idLock.RLock()
+	addr, ok := s.ids[id]
+	s.idLock.RUnlock()
+	if ok {
+		return addr
+	}
+
+	// The cookie may be issued by another gateway replica.
+	for _, _addr := range addrs() {
+		if s.endpointID(_addr) == id {
+			return _addr
+		}
+	}
+	return ""
+}
+
+// Set binds the session of the request to the endpoint address.
+func (s *sessionAffinity) Set(ctx *apigw.Context, addr string) {
+	if s.store == nil {
+		cookie := &http.Cookie{
+			Name:     s.conf.Name,
+			Value:    s.sign(s.endpointID(addr)),
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   s.conf.Secure,
+			SameSite: s.sameSite,
+		}
+		if s.ttl > 0 {
+			cookie.MaxAge = int(s.ttl / time.Second)
+		}
+		ctx.SetCookie(cookie)
+	} else if id := s.getID(ctx); id != "" {
+		s.store.Set(id, addr)
+	}
+}
+
+// endpointID returns the opaque id of the endpoint address, which is
+// the truncated HMAC-SHA256 of the address and is cached to be resolved.
+func (s *sessionAffinity) endpointID(addr string) string {
+	id := base64.RawURLEncoding.EncodeToString(s.mac("endpoint|" + addr)[:16])
+
+	s.idLock.RLock()
+	_, ok := s.ids[id]
+	s.idLock.RUnlock()
+	if !ok {
+		s.idLock.Lock()
+		s.ids[id] = addr
+		s.idLock.Unlock()
+	}
+	return id
+}
+
+// sign returns the value of the cookie, which is
+// "base64(ID|EXPIRATION).base64(HMAC-SHA256)".
+func (s *sessionAffinity) sign(id string) string {
+	var expire int64
+	if s.ttl > 0 {
+		expire = time.Now().Add(s.ttl).Unix()
+	}
+
+	payload := id + "|" + strconv.FormatInt(expire, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+func (s *sessionAffinity) verify(value string) (id string) {
+	index := strings.IndexByte(value, '.')
+	if index < 0 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(value[:index])
+	if err != nil {
+		return ""
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(value[index+1:])
+	if err != nil || !hmac.Equal(sum, s.mac(string(payload))) {
+		return ""
+	}
+
+	index = strings.LastIndexByte(string(payload), '|')
+	if index < 0 {
+		return ""
+	}
+	expire, err := strconv.ParseInt(string(payload[index+1:]), 10, 64)
+	if err != nil || (expire > 0 && time.Now().Unix() > expire) {
+		return ""
+	}
+	return string(payload[:index])
+}
+
+func (s *sessionAffinity) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// sessionStore is a memory store of the sessions bounded by the maximum
+// number and the idle time to live, which evicts the least recently used.
+type sessionStore struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	maxsize int
+	list    *list.List
+	items   map[string]*list.Element
+}
+
+type sessionItem struct {
+	id     string
+	addr   string
+	expire time.Time
+}
+
+func newSessionStore(maxsize int, ttl time.Duration) *sessionStore {
+	return &sessionStore{
+		ttl:     ttl,
+		maxsize: maxsize,
+		list:    list.New(),
+		items:   make(map[string]*list.Element, 64),
+	}
+}
+
+func (s *sessionStore) Get(id string) (addr string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	elem, ok := s.items[id]
+	if !ok {
+		return ""
+	}
+
+	item := elem.Value.(*sessionItem)
+	if s.ttl > 0 {
+		now := time.Now()
+		if now.After(item.expire) {
+			s.list.Remove(elem)
+			delete(s.items, id)
+			return ""
+		}
+		item.expire = now.Add(s.ttl)
+	}
+
+	s.list.MoveToFront(elem)
+	return item.addr
+}
+
+func (s *sessionStore) Set(id, addr string) {
+	var expire time.Time
+	if s.ttl > 0 {
+		expire = time.Now().Add(s.ttl)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if elem, ok := s.items[id]; ok {
+		item := elem.Value.(*sessionItem)
+		item.addr, item.expire = addr, expire
+		s.list.MoveToFront(elem)
+		return
+	}
+
+	s.items[id] = s.list.PushFront(&sessionItem{id: id, addr: addr, expire: expire})
+	for s.list.Len() > s.maxsize {
+		elem := s.list.Back()
+		s.list.Remove(elem)
+		delete(s.items, elem.Value.(*sessionItem).id)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSessionConfigGetSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "secret")
+	emptyFile := filepath.Join(dir, "empty")
+	ioutil.WriteFile(secretFile, []byte(" file-secret\n"), 0600)
+	ioutil.WriteFile(emptyFile, []byte("\n"), 0600)
+
+	os.Setenv("APIGW_TEST_SESSION_SECRET", "env-secret")
+	defer os.Unsetenv("APIGW_TEST_SESSION_SECRET")
+
+	tests := []struct {
+		name   string
+		conf   SessionConfig
+		secret string
+		fail   bool
+	}{
+		{"inline", SessionConfig{Secret: "secret", SecretEnv: "APIGW_TEST_SESSION_SECRET"}, "secret", false},
+		{"masked", SessionConfig{Secret: MaskedSessionSecret}, "", true},
+		{"env", SessionConfig{SecretEnv: "APIGW_TEST_SESSION_SECRET"}, "env-secret", false},
+		{"missing env", SessionConfig{SecretEnv: "APIGW_TEST_SESSION_MISSING"}, "", true},
+		{"file", SessionConfig{SecretFile: secretFile}, "file-secret", false},
+		{"empty file", SessionConfig{SecretFile: emptyFile}, "", true},
+		{"missing file", SessionConfig{SecretFile: filepath.Join(dir, "missing")}, "", true},
+		{"none", SessionConfig{}, "", true},
+	}
+
+	for _, tt := range tests {
+		secret, err := tt.conf.getSecret()
+		if tt.fail && err == nil {
+			t.Errorf("%s: expect an error, but got nil", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		} else if secret != tt.secret {
+			t.Errorf("%s: expect the secret '%s', but got '%s'", tt.name, tt.secret, secret)
+		}
+	}
+}
+
+func TestSessionAffinitySignedCookie(t *testing.T) {
+	newAffinity := func(secret string) *sessionAffinity {
+		conf := SessionConfig{Type: "signed_cookie", Secret: secret, TTL: "1h"}
+		s, err := conf.newSessionAffinity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	s := newAffinity("secret")
+	id := s.endpointID("127.0.0.1:8001")
+	value := s.sign(id)
+
+	// signPayload signs the payload with the secret, which may be expired.
+	signPayload := func(s *sessionAffinity, id string, expire int64) string {
+		payload := id + "|" + strconv.FormatInt(expire, 10)
+		return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+			base64.RawURLEncoding.EncodeToString(s.mac(payload))
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		id    string
+	}{
+		{"valid", value, id},
+		{"never expire", signPayload(s, id, 0), id},
+		{"expired", signPayload(s, id, time.Now().Add(-time.Second).Unix()), ""},
+		{"another secret", newAffinity("another").sign(id), ""},
+		{"tampered payload", base64.RawURLEncoding.EncodeToString([]byte("id|0")) + value[len(value)-44:], ""},
+		{"tampered signature", value[:len(value)-2] + "AA", ""},
+		{"no signature", base64.RawURLEncoding.EncodeToString([]byte(id + "|0")), ""},
+		{"invalid base64", "!!!.!!!", ""},
+	}
+
+	for _, tt := range tests {
+		if id := s.verify(tt.value); id != tt.id {
+			t.Errorf("%s: expect the id '%s', but got '%s'", tt.name, tt.id, id)
+		}
+	}
+
+	// The opaque id is resolved by another replica with the same secret.
+	other := newAffinity("secret")
+	if other.endpointID("127.0.0.1:8001") != id {
+		t.Errorf("the replicas with the same secret generate the different ids")
+	} else if newAffinity("another").endpointID("127.0.0.1:8001") == id {
+		t.Errorf("the replicas with the different secrets generate the same id")
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	s := newSessionStore(2, time.Hour)
+	s.Set("id1", "127.0.0.1:8001")
+	s.Set("id2", "127.0.0.1:8002")
+	s.Get("id1")                   // id1 is used recently.
+	s.Set("id3", "127.0.0.1:8003") // id2 is evicted.
+	s.Set("id1", "127.0.0.1:8004") // id1 is updated.
+
+	// Expire the session id3.
+	s.items["id3"].Value.(*sessionItem).expire = time.Now().Add(-time.Second)
+
+	tests := []struct {
+		id   string
+		addr string
+	}{
+		{"id1", "127.0.0.1:8004"},
+		{"id2", ""},
+		{"id3", ""},
+		{"id4", ""},
+	}
+
+	for _, tt := range tests {
+		if addr := s.Get(tt.id); addr != tt.addr {
+			t.Errorf("%s: expect the address '%s', but got '%s'", tt.id, tt.addr, addr)
+		}
+	}
+
+	if _len := s.list.Len(); _len != 1 {
+		t.Errorf("expect 1 session left, but got %d", _len)
+	}
+}
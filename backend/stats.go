@@ -0,0 +1,71 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// ewmaDecay is the weight of the old latency in EWMA.
+const ewmaDecay = 0.8
+
+// endpointStats is the statistics of the requests forwarded to the endpoint.
+type endpointStats struct {
+	inflight int64
+	latency  int64 // EWMA, the unit is nanosecond.
+}
+
+func (s *endpointStats) Inflight() int64 { return atomic.LoadInt64(&s.inflight) }
+func (s *endpointStats) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.latency))
+}
+
+func (s *endpointStats) observe(latency time.Duration) {
+	for {
+		old := atomic.LoadInt64(&s.latency)
+		_new := int64(latency)
+		if old > 0 {
+			_new = int64(ewmaDecay*float64(old) + (1-ewmaDecay)*float64(latency))
+		}
+		if atomic.CompareAndSwapInt64(&s.latency, old, _new) {
+			return
+		}
+	}
+}
+
+// statsEndpoint collects the statistics of the requests by the endpoint.
+type statsEndpoint struct {
+	loadbalancer.Endpoint
+	stats *endpointStats
+}
+
+func (e statsEndpoint) Unwrap() loadbalancer.Endpoint { return e.Endpoint }
+func (e statsEndpoint) Inflight() int64               { return e.stats.Inflight() }
+func (e statsEndpoint) Latency() time.Duration        { return e.stats.Latency() }
+func (e statsEndpoint) Weight() int                   { return getWeight(e.Endpoint) }
+func (e statsEndpoint) RoundTrip(c context.Context, r loadbalancer.Request) (
+	loadbalancer.Response, error) {
+	atomic.AddInt64(&e.stats.inflight, 1)
+	defer atomic.AddInt64(&e.stats.inflight, -1)
+
+	start := time.Now()
+	resp, err := e.Endpoint.RoundTrip(c, r)
+	e.stats.observe(time.Since(start))
+	return resp, err
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xgfone/goapp/log"
+)
+
+// TLSReloadInterval is the minimum interval to check whether the certificate
+// files have been changed.
+var TLSReloadInterval = time.Second * 10
+
+// TLSConfig is the TLS options to connect to the backend, which is configured
+// by the metadata "tls" of the http backend or its http health check.
+//
+// The certificate files are reloaded when they are changed.
+type TLSConfig struct {
+	// ServerName is the server name to verify the certificate and sent as
+	// SNI, which is the host of the request url by default.
+	ServerName string `mapstructure:"server_name" json:"server_name,omitempty"`
+
+	// CAFile is the file of the PEM certificates to verify the server,
+	// which are the system root certificates by default.
+	CAFile string `mapstructure:"ca_file" json:"ca_file,omitempty"`
+
+	// CertFile and KeyFile are the files of the PEM client certificate
+	// and its private key, which are sent to the server for mTLS.
+	CertFile string `mapstructure:"cert_file" json:"cert_file,omitempty"`
+	KeyFile  string `mapstructure:"key_file" json:"key_file,omitempty"`
+
+	// MinVersion is the minimum TLS version, such as "1.2" or "1.3",
+	// which is the default of the Go standard library by default.
+	MinVersion string `mapstructure:"min_version" json:"min_version,omitempty"`
+
+	// InsecureSkipVerify skips to verify the certificate of the server.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// IsZero reports whether no TLS option is configured.
+func (c TLSConfig) IsZero() bool { return c == TLSConfig{} }
+
+// TLSConfig returns a new tls.Config by the options to connect to the host,
+// which is used as the server name if ServerName is empty.
+func (c TLSConfig) TLSConfig(host string) (*tls.Config, error) {
+	serverName := c.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+
+	conf := &tls.Config{ServerName: serverName, InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls min version '%s'", c.MinVersion)
+		}
+		conf.MinVersion = version
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("the cert file and the key file must be given together")
+	} else if c.CAFile == "" && c.CertFile == "" {
+		return conf, nil
+	}
+
+	loader := &tlsFileLoader{conf: c}
+	if err := loader.load(); err != nil {
+		return nil, err
+	}
+
+	if c.CertFile != "" {
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			_, cert := loader.get()
+			return cert, nil
+		}
+	}
+
+	// Verify the server by ourselves in order to use the reloaded CAs.
+	// The server name is captured here, because that of the connection
+	// state is empty for the IP address.
+	if c.CAFile != "" && !c.InsecureSkipVerify {
+		conf.InsecureSkipVerify = true
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if serverName == "" {
+				return errors.New("missing the server name to verify the certificate")
+			}
+
+			roots, _ := loader.get()
+			opts := x509.VerifyOptions{
+				Roots:         roots,
+				DNSName:       serverName,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+
+	return conf, nil
+}
+
+// tlsFileLoader loads the CA and client certificates from the files,
+// and reloads them when the files are changed.
+type tlsFileLoader struct {
+	conf TLSConfig
+
+	lock    sync.Mutex
+	checked time.Time
+	mtimes  [3]time.Time
+	roots   *x509.CertPool
+	cert    *tls.Certificate
+}
+
+func (l *tlsFileLoader) get() (*x509.CertPool, *tls.Certificate) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now := time.Now(); now.Sub(l.checked) >= TLSReloadInterval {
+		l.checked = now
+		if mtimes := l.modTimes(); mtimes != l.mtimes {
+			if err := l.reload(mtimes); err != nil {
+				log.Error("fail to reload the tls certificates",
+					log.F("cafile", l.conf.CAFile), log.F("certfile", l.conf.CertFile),
+					log.E(err))
+			}
+		}
+	}
+
+	return l.roots, l.cert
+}
+
+func (l *tlsFileLoader) load() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.checked = time.Now()
+	return l.reload(l.modTimes())
+}
+
+func (l *tlsFileLoader) modTimes() (mtimes [3]time.Time) {
+	for i, file := range []string{l.conf.CAFile, l.conf.CertFile, l.conf.KeyFile} {
+		if file != "" {
+			if fi, err := os.Stat(file); err == nil {
+				mtimes[i] = fi.ModTime()
+			}
+		}
+	}
+	return
+}
+
+// reload loads the certificates from the files, and keeps the old ones
+// if failing.
+func (l *tlsFileLoader) reload(mtimes [3]time.Time) error {
+	var roots *x509.CertPool
+	if l.conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(l.conf.CAFile)
+		if err != nil {
+			return err
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in the ca file '%s'", l.conf.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if l.conf.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(l.conf.CertFile, l.conf.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	l.roots, l.cert, l.mtimes = roots, cert, mtimes
+	return nil
+}
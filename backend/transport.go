@@ -0,0 +1,425 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+// TransportOptions is the options of the http transport to forward
+// the requests to the backends.
+type TransportOptions struct {
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int // ZERO means no limit.
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration // ZERO means no timeout.
+	KeepAlive             bool
+	HTTP2                 bool
+}
+
+// DefaultTransportOptions is the default options of the http transport,
+// which is used by the http backends without their own.
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     time.Second * 30,
+	DialTimeout:         time.Second * 30,
+	KeepAlive:           true,
+	HTTP2:               true,
+}
+
+// TransportConfig is the options of the http transport of the backend,
+// which is configured by the metadata "transport" of the http backend.
+// The unset options are inherited from DefaultTransportOptions.
+//
+// The backends with the same transport and tls options share
+// the same transport.
+type TransportConfig struct {
+	// MaxIdleConns is the maximum number of the idle connections
+	// to the backend host.
+	MaxIdleConns int `mapstructure:"max_idle_conns" json:"max_idle_conns,omitempty"`
+
+	// MaxConns is the maximum number of the total connections,
+	// including the active and idle ones, to the backend host.
+	MaxConns int `mapstructure:"max_conns" json:"max_conns,omitempty"`
+
+	IdleConnTimeout       string `mapstructure:"idle_conn_timeout" json:"idle_conn_timeout,omitempty"`
+	DialTimeout           string `mapstructure:"dial_timeout" json:"dial_timeout,omitempty"`
+	ResponseHeaderTimeout string `mapstructure:"response_header_timeout" json:"response_header_timeout,omitempty"`
+
+	// KeepAlive reports whether to reuse the connections by HTTP keep-alive.
+	KeepAlive *bool `mapstructure:"keep_alive" json:"keep_alive,omitempty"`
+
+	// HTTP2 reports whether to negotiate HTTP/2 with the https backend.
+	HTTP2 *bool `mapstructure:"http2" json:"http2,omitempty"`
+}
+
+// TransportOptions returns the transport options, whose unset ones
+// are inherited from the default.
+func (c TransportConfig) TransportOptions(defaults TransportOptions) (o TransportOptions, err error) {
+	if c.MaxIdleConns < 0 {
+		return o, fmt.Errorf("invalid max idle conns '%d'", c.MaxIdleConns)
+	} else if c.MaxConns < 0 {
+		return o, fmt.Errorf("invalid max conns '%d'", c.MaxConns)
+	}
+
+	o = defaults
+	if c.MaxIdleConns > 0 {
+		o.MaxIdleConnsPerHost = c.MaxIdleConns
+	}
+	if c.MaxConns > 0 {
+		o.MaxConnsPerHost = c.MaxConns
+	}
+	if c.KeepAlive != nil {
+		o.KeepAlive = *c.KeepAlive
+	}
+	if c.HTTP2 != nil {
+		o.HTTP2 = *c.HTTP2
+	}
+
+	for _, d := range []struct {
+		value string
+		dst   *time.Duration
+	}{
+		{c.IdleConnTimeout, &o.IdleConnTimeout},
+		{c.DialTimeout, &o.DialTimeout},
+		{c.ResponseHeaderTimeout, &o.ResponseHeaderTimeout},
+	} {
+		if d.value != "" {
+			if *d.dst, err = time.ParseDuration(d.value); err != nil {
+				return
+			} else if *d.dst < 0 {
+				return o, fmt.Errorf("invalid timeout '%s'", d.value)
+			}
+		}
+	}
+
+	return
+}
+
+type transportKey struct {
+	tls  TLSConfig
+	host string
+	opts TransportOptions
+}
+
+// httpClient is the http client shared by the http backends with the same
+// transport options, which is referenced by the forwarders using them.
+type httpClient struct {
+	*http.Client
+	key     transportKey
+	refs    int
+	created time.Time
+}
+
+// unusedClientTimeout is the duration to keep the new client not referenced
+// by any forwarder, which has been built but not added yet.
+const unusedClientTimeout = time.Minute
+
+var (
+	clientLock sync.Mutex
+	clients    = make(map[transportKey]*httpClient, 4)
+)
+
+// getHTTPClient returns the http client with the dedicated transport by the
+// transport and tls options to connect to the host, which is shared by the
+// backends with the same.
+//
+// Before creating a new client, the clients which are not referenced by any
+// forwarder for a while are released, such as the ones of the backends
+// never added.
+func getHTTPClient(opts TransportOptions, tlsconf TLSConfig, host string) (*httpClient, error) {
+	key := transportKey{tls: tlsconf, opts: opts}
+	if !tlsconf.IsZero() {
+		key.host = host
+	}
+
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	if client, ok := clients[key]; ok {
+		return client, nil
+	}
+
+	now := time.Now()
+	for _, client := range clients {
+		if client.refs == 0 && now.Sub(client.created) > unusedClientTimeout {
+			client.release()
+		}
+	}
+	pools.Range(func(addr, v interface{}) bool {
+		if p := v.(*pool); p.refs == 0 && p.idle() {
+			pools.Delete(addr)
+		}
+		return true
+	})
+
+	tp := http.DefaultTransport.(*http.Transport).Clone()
+	if !tlsconf.IsZero() {
+		conf, err := tlsconf.TLSConfig(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls: %s", err)
+		}
+		tp.TLSClientConfig = conf
+	}
+
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: time.Second * 30}
+	tp.DialContext = countDialContext(dialer.DialContext)
+	tp.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	tp.MaxConnsPerHost = opts.MaxConnsPerHost
+	tp.IdleConnTimeout = opts.IdleConnTimeout
+	tp.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	tp.DisableKeepAlives = !opts.KeepAlive
+	tp.ForceAttemptHTTP2 = opts.HTTP2
+	if !opts.HTTP2 {
+		// The non-nil empty map disables HTTP/2.
+		tp.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	client := &httpClient{
+		Client:  &http.Client{Transport: countTransport{tp}},
+		created: now,
+		key:     key,
+	}
+	clients[key] = client
+	return client, nil
+}
+
+// release deletes the client from the cache and closes its idle connections,
+// which must be called with the lock.
+func (c *httpClient) release() {
+	if clients[c.key] == c {
+		delete(clients, c.key)
+	}
+	c.CloseIdleConnections()
+}
+
+// clientBackend is the http backend with the shared http client.
+type clientBackend struct {
+	lb.Backend
+	client *httpClient
+}
+
+func (b clientBackend) Unwrap() loadbalancer.Endpoint { return b.Backend }
+func (b clientBackend) UnwrapBackend() lb.Backend     { return b.Backend }
+
+func getClientBackend(b lb.Backend) (clientBackend, bool) {
+	for ep := loadbalancer.Endpoint(b); ep != nil; {
+		switch e := ep.(type) {
+		case clientBackend:
+			return e, true
+		case lb.BackendUnwrap:
+			ep = e.UnwrapBackend()
+		case loadbalancer.EndpointUnwrap:
+			ep = e.Unwrap()
+		default:
+			return clientBackend{}, false
+		}
+	}
+	return clientBackend{}, false
+}
+
+// acquireHTTPClient references the http client and the connection pool
+// of the http backend, which is called when the forwarder adds it.
+func acquireHTTPClient(b lb.Backend) {
+	cb, ok := getClientBackend(b)
+	if !ok {
+		return
+	}
+
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	// The client not referenced may have been released before the backend
+	// is added, so cache it again.
+	cb.client.refs++
+	if clients[cb.client.key] == nil {
+		clients[cb.client.key] = cb.client
+	}
+
+	if addr, err := getHostPort(b.String()); err == nil {
+		getPool(addr).refs++
+	}
+}
+
+// releaseHTTPClient dereferences the http client and the connection pool
+// of the http backend, which is called when the forwarder deletes it.
+//
+// The client and the pool are released if they are not referenced any more.
+func releaseHTTPClient(b lb.Backend) {
+	cb, ok := getClientBackend(b)
+	if !ok {
+		return
+	}
+
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	if cb.client.refs--; cb.client.refs <= 0 {
+		cb.client.refs = 0
+		cb.client.release()
+	}
+
+	if addr, err := getHostPort(b.String()); err == nil {
+		p := getPool(addr)
+		if p.refs--; p.refs <= 0 {
+			p.refs = 0
+			if p.idle() {
+				pools.Delete(addr)
+			}
+		}
+	}
+}
+
+// discardHTTPClient releases the http client of the http backend at once
+// if it is not referenced by any forwarder, such as the one of the backend
+// built but not added, or replaced in the backend group without forwarders.
+func discardHTTPClient(b lb.Backend) {
+	if cb, ok := getClientBackend(b); ok {
+		clientLock.Lock()
+		if cb.client.refs <= 0 {
+			cb.client.release()
+		}
+		clientLock.Unlock()
+	}
+}
+
+// PoolStats is the statistics of the connections to the backend host.
+type PoolStats struct {
+	// Open is the number of the open connections.
+	Open int64 `json:"open"`
+
+	// Active is the number of the in-flight requests.
+	Active int64 `json:"active"`
+
+	// Dials is the total number of the dialed connections.
+	Dials int64 `json:"dials"`
+}
+
+// pool is the statistics of the connection pool to the backend host,
+// which is referenced by the http backends of the forwarders.
+type pool struct {
+	PoolStats
+	refs int // Protected by clientLock.
+}
+
+func (p *pool) idle() bool {
+	return atomic.LoadInt64(&p.Open) <= 0 && atomic.LoadInt64(&p.Active) <= 0
+}
+
+var pools sync.Map // map[string]*pool
+
+func getPool(addr string) *pool {
+	if v, ok := pools.Load(addr); ok {
+		return v.(*pool)
+	}
+	v, _ := pools.LoadOrStore(addr, new(pool))
+	return v.(*pool)
+}
+
+func getPoolStats(addr string) *PoolStats { return &getPool(addr).PoolStats }
+
+// GetPoolStats returns the statistics of the connections to the backend
+// by its address "host:port" or url, which are counted over all the
+// transports of the http backends.
+//
+// Return false if no connection has been dialed to the backend.
+func GetPoolStats(addrOrURL string) (stats PoolStats, ok bool) {
+	addr, err := getHostPort(addrOrURL)
+	if err != nil {
+		return
+	}
+
+	v, ok := pools.Load(addr)
+	if ok {
+		s := &v.(*pool).PoolStats
+		stats.Open = atomic.LoadInt64(&s.Open)
+		stats.Active = atomic.LoadInt64(&s.Active)
+		stats.Dials = atomic.LoadInt64(&s.Dials)
+	}
+	return
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func countDialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		stats := getPoolStats(addr)
+		atomic.AddInt64(&stats.Dials, 1)
+		atomic.AddInt64(&stats.Open, 1)
+		return &countConn{Conn: conn, stats: stats}, nil
+	}
+}
+
+type countConn struct {
+	net.Conn
+	stats *PoolStats
+	once  sync.Once
+}
+
+func (c *countConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&c.stats.Open, -1) })
+	return c.Conn.Close()
+}
+
+// countTransport counts the in-flight requests until the response body
+// is closed.
+type countTransport struct{ *http.Transport }
+
+func (t countTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	addr, err := getHostPort(r.URL.Scheme + "://" + r.URL.Host)
+	if err != nil {
+		return t.Transport.RoundTrip(r)
+	}
+
+	stats := getPoolStats(addr)
+	atomic.AddInt64(&stats.Active, 1)
+	resp, err := t.Transport.RoundTrip(r)
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		// The connection of the upgraded protocol is not managed by the pool.
+		atomic.AddInt64(&stats.Active, -1)
+		return resp, err
+	}
+
+	resp.Body = &countBody{ReadCloser: resp.Body, stats: stats}
+	return resp, nil
+}
+
+type countBody struct {
+	io.ReadCloser
+	stats *PoolStats
+	once  sync.Once
+}
+
+func (b *countBody) Close() error {
+	b.once.Do(func() { atomic.AddInt64(&b.stats.Active, -1) })
+	return b.ReadCloser.Close()
+}
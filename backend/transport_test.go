@@ -0,0 +1,87 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/xgfone/apigw"
+)
+
+func TestHTTPClientRefs(t *testing.T) {
+	route := apigw.NewRoute("www.example.com", "/path", "GET")
+	newBackend := func(url string, timeout string) clientBackend {
+		conf := Backend{Type: "http", Metadata: map[string]interface{}{"url": url}}
+		if timeout != "" {
+			conf.Metadata["transport"] = map[string]interface{}{"dial_timeout": timeout}
+		}
+
+		b, err := conf.Backend(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cb, ok := getClientBackend(b)
+		if !ok {
+			t.Fatalf("the backend '%s' has no http client", url)
+		}
+		return cb
+	}
+
+	b1 := newBackend("http://127.0.0.1:18001", "3s")
+	b2 := newBackend("http://127.0.0.1:18002", "3s")
+	b3 := newBackend("http://127.0.0.1:18003", "4s")
+	if b1.client != b2.client {
+		t.Fatal("the backends with the same transport do not share the client")
+	} else if b1.client == b3.client {
+		t.Fatal("the backends with the different transports share the client")
+	}
+
+	isCached := func(c *httpClient) bool {
+		clientLock.Lock()
+		defer clientLock.Unlock()
+		return clients[c.key] == c
+	}
+
+	steps := []struct {
+		op     func(b clientBackend)
+		b      clientBackend
+		refs   int
+		cached bool
+	}{
+		{func(b clientBackend) { acquireHTTPClient(b) }, b1, 1, true},
+		{func(b clientBackend) { acquireHTTPClient(b) }, b2, 2, true},
+		{func(b clientBackend) { releaseHTTPClient(b) }, b1, 1, true},
+		{func(b clientBackend) { discardHTTPClient(b) }, b1, 1, true},
+		{func(b clientBackend) { releaseHTTPClient(b) }, b2, 0, false},
+		{func(b clientBackend) { acquireHTTPClient(b) }, b1, 1, true}, // Re-cache it.
+		{func(b clientBackend) { releaseHTTPClient(b) }, b1, 0, false},
+		{func(b clientBackend) { discardHTTPClient(b) }, b3, 0, false},
+	}
+
+	for i, step := range steps {
+		step.op(step.b)
+
+		clientLock.Lock()
+		refs := step.b.client.refs
+		clientLock.Unlock()
+
+		if refs != step.refs {
+			t.Errorf("%d: expect %d references, but got %d", i, step.refs, refs)
+		} else if cached := isCached(step.b.client); cached != step.cached {
+			t.Errorf("%d: expect cached %v, but got %v", i, step.cached, cached)
+		}
+	}
+}
@@ -0,0 +1,811 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/xgfone/apigateway/backend"
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/apigw/forward/lb"
+	"gopkg.in/yaml.v2"
+)
+
+// BackendGroupConfig is the configuration of the backend group.
+type BackendGroupConfig struct {
+	Host string `json:"host,omitempty" validate:"zero|hostname_rfc1123"`
+	Name string `json:"name" validate:"required"`
+	backend.GroupConfig
+	Backends backend.Backends `json:"backends,omitempty"`
+}
+
+// RouteConfig is the configuration of the route with its forwarder
+// settings and backends.
+type RouteConfig struct {
+	apigw.Route
+	backend.ForwarderConfig
+	Backends backend.Backends `json:"backends,omitempty"`
+}
+
+// GatewayConfig is the whole configuration of the gateway.
+type GatewayConfig struct {
+	Hosts         []string             `json:"hosts"`
+	BackendGroups []BackendGroupConfig `json:"backend_groups,omitempty"`
+	Routes        []RouteConfig        `json:"routes,omitempty"`
+}
+
+func getBackendConfigs(bs []lb.Backend) backend.Backends {
+	sort.Slice(bs, func(i, j int) bool { return bs[i].String() < bs[j].String() })
+	backends := make(backend.Backends, len(bs))
+	for i, _len := 0, len(bs); i < _len; i++ {
+		backends[i] = backend.NewBackend(bs[i])
+	}
+	return backends
+}
+
+// newRouteConfig returns the configuration of the route with the forwarder
+// settings but without the backends.
+func newRouteConfig(route apigw.Route) RouteConfig {
+	r := RouteConfig{Route: route}
+	if f, ok := route.Forwarder.(*backend.Forwarder); ok {
+		r.ForwarderConfig = f.Config()
+	}
+	return r
+}
+
+// exportGatewayConfig exports the configuration of the gateway.
+//
+// Notice: the route whose forwarder is not lb.Forwarder is ignored.
+func exportGatewayConfig(gw *lb.Gateway) (conf GatewayConfig) {
+	conf.Hosts = gw.GetHosts()
+	sort.Strings(conf.Hosts)
+
+	for _, host := range conf.Hosts {
+		if m := gw.GetBackendGroupManager(host); m != nil {
+			groups := m.GetBackendGroups()
+			sort.Slice(groups, func(i, j int) bool {
+				return groups[i].Name() < groups[j].Name()
+			})
+
+			for _, group := range groups {
+				conf.BackendGroups = append(conf.BackendGroups, BackendGroupConfig{
+					Host:        host,
+					Name:        group.Name(),
+					GroupConfig: backend.GetGroupConfig(group),
+					Backends:    getBackendConfigs(group.GetBackends()),
+				})
+			}
+		}
+
+		routes, _ := gw.GetRoutes(host)
+		sort.Slice(routes, func(i, j int) bool {
+			if routes[i].Path == routes[j].Path {
+				return routes[i].Method < routes[j].Method
+			}
+			return routes[i].Path < routes[j].Path
+		})
+
+		for _, route := range routes {
+			if forwarder, ok := route.Forwarder.(lb.Forwarder); ok {
+				r := newRouteConfig(route)
+				r.Backends = getBackendConfigs(forwarder.GetBackends())
+				conf.Routes = append(conf.Routes, r)
+			}
+		}
+	}
+
+	return
+}
+
+// filterGatewayConfig returns a new configuration only containing the hosts,
+// the backend groups and the routes whose host is allowed.
+func filterGatewayConfig(conf GatewayConfig, allow func(host string) bool) GatewayConfig {
+	hosts := make([]string, 0, len(conf.Hosts))
+	for _, host := range conf.Hosts {
+		if allow(host) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	var groups []BackendGroupConfig
+	for _, bg := range conf.BackendGroups {
+		if allow(bg.Host) {
+			groups = append(groups, bg)
+		}
+	}
+
+	var routes []RouteConfig
+	for _, r := range conf.Routes {
+		if allow(r.Host) {
+			routes = append(routes, r)
+		}
+	}
+
+	return GatewayConfig{Hosts: hosts, BackendGroups: groups, Routes: routes}
+}
+
+// maskGatewayConfig returns a copy of the configuration, the secrets of which
+// are masked to be returned by the admin api.
+func maskGatewayConfig(conf GatewayConfig) GatewayConfig {
+	if len(conf.Routes) > 0 {
+		routes := make([]RouteConfig, len(conf.Routes))
+		for i, r := range conf.Routes {
+			r.ForwarderConfig = r.ForwarderConfig.MaskSecrets()
+			routes[i] = r
+		}
+		conf.Routes = routes
+	}
+	return conf
+}
+
+// unmaskGatewayConfig returns a copy of the configuration, the masked secrets
+// of which are replaced with the ones of the same routes in current.
+func unmaskGatewayConfig(conf, current GatewayConfig) GatewayConfig {
+	if len(conf.Routes) > 0 {
+		curroutes := make(map[string]RouteConfig, len(current.Routes))
+		for _, r := range current.Routes {
+			curroutes[routeConfigKey(r.Route)] = r
+		}
+
+		routes := make([]RouteConfig, len(conf.Routes))
+		for i, r := range conf.Routes {
+			if cur, ok := curroutes[routeConfigKey(r.Route)]; ok {
+				r.ForwarderConfig = r.ForwarderConfig.UnmaskSecrets(cur.ForwarderConfig)
+			}
+			routes[i] = r
+		}
+		conf.Routes = routes
+	}
+	return conf
+}
+
+// maskConfigChanges returns a copy of the changes, the secrets of which
+// are masked to be returned by the admin api.
+func maskConfigChanges(changes []ConfigChange) []ConfigChange {
+	masks := make([]ConfigChange, len(changes))
+	for i, c := range changes {
+		if c.Forwarder != nil {
+			fc := c.Forwarder.MaskSecrets()
+			c.Forwarder = &fc
+		}
+		masks[i] = c
+	}
+	return masks
+}
+
+// applyGatewayConfig applies the configuration into the gateway in order of
+// the hosts, the backend groups and the routes.
+//
+// The failed host, backend group or route is skipped, and the others
+// go on being applied. Return the errors of the skipped ones.
+func applyGatewayConfig(gw *lb.Gateway, conf GatewayConfig) (errs []error) {
+	for _, host := range conf.Hosts {
+		if err := gw.AddHost(host); err != nil {
+			errs = append(errs, fmt.Errorf("fail to add the host '%s': %v", host, err))
+		}
+	}
+
+	for _, bg := range conf.BackendGroups {
+		if err := addBackendGroup(gw, bg); err != nil {
+			errs = append(errs, fmt.Errorf("fail to add the backend group '%s' of the host '%s': %v",
+				bg.Name, bg.Host, err))
+		}
+	}
+
+	for _, r := range conf.Routes {
+		if err := addRoute(gw, r); err != nil {
+			errs = append(errs, fmt.Errorf("fail to add the route '%s': %v", r.Name(), err))
+		}
+	}
+
+	return
+}
+
+// addBackendGroup adds the backend group with its backends into the gateway.
+// If the backend group has existed, only add the backends into it,
+// and update its configuration if it is not ZERO.
+//
+// If the backend has existed in the group, update its weight in place,
+// or replace it if its configuration is changed.
+func addBackendGroup(gw *lb.Gateway, bg BackendGroupConfig) error {
+	m := gw.GetBackendGroupManager(bg.Host)
+	if m == nil {
+		return apigw.ErrNoHost
+	}
+
+	// The backends inherit the transport of the group in use.
+	gconf := bg.GroupConfig
+	if group := m.GetBackendGroup(bg.Name); group != nil && gconf == (backend.GroupConfig{}) {
+		gconf = backend.GetGroupConfig(group)
+	}
+
+	backends, err := bg.Backends.GroupBackends(apigw.Route{Host: bg.Host}, gconf)
+	if err != nil {
+		return err
+	}
+
+	conf, err := backend.NewGroupBackendConfig(bg.GroupConfig)
+	if err != nil {
+		return err
+	}
+
+	group := m.AddOrNewBackendGroup(bg.Name, conf)
+	if bg.GroupConfig != (backend.GroupConfig{}) &&
+		!reflect.DeepEqual(backend.GetGroupConfig(group), bg.GroupConfig) {
+		if err = backend.UpdateGroupConfig(group, bg.GroupConfig); err != nil {
+			return err
+		}
+	}
+
+	olds := make(map[string]lb.Backend, len(backends))
+	for _, b := range group.GetBackends() {
+		olds[b.String()] = b
+	}
+
+	for _, b := range backends {
+		if old, ok := olds[b.String()]; ok {
+			if backend.UpdateBackend(old, b) {
+				continue
+			}
+			group.DelBackend(old)
+		}
+		group.AddBackend(b)
+	}
+
+	return nil
+}
+
+// lookupBackends returns the backends whose addresses are in addrs
+// but not in excludes.
+func lookupBackends(backends []lb.Backend, addrs []string, excludes ...string) []lb.Backend {
+	includes := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		includes[addr] = struct{}{}
+	}
+	for _, addr := range excludes {
+		delete(includes, addr)
+	}
+
+	bs := make([]lb.Backend, 0, len(includes))
+	for _, b := range backends {
+		if _, ok := includes[b.String()]; ok {
+			bs = append(bs, b)
+		}
+	}
+	return bs
+}
+
+// addRoute registers the route with its backends into the gateway.
+// If the route has been registered, only add the backends into it.
+func addRoute(gw *lb.Gateway, r RouteConfig) (err error) {
+	backends, err := r.Backends.Backends(r.Route)
+	if err != nil {
+		return
+	}
+
+	if r.Forwarder, err = backend.NewForwarder(r.Name(), r.ForwarderConfig); err != nil {
+		return
+	} else if r.Route, err = gw.RegisterRoute(r.Route); err != nil {
+		return
+	}
+
+	r.Route.Forwarder.(lb.Forwarder).AddBackends(backends...)
+	return
+}
+
+// updateRoute replaces the forwarder settings and the backends of the route
+// in place, which must have been registered.
+func updateRoute(gw *lb.Gateway, r RouteConfig) (err error) {
+	route, err := gw.GetRoute(r.Host, r.Path, r.Method)
+	if err != nil {
+		return
+	} else if !equalRoutePlugins(route.Plugins, r.Plugins) {
+		return fmt.Errorf("the plugins of the route cannot be updated in place")
+	}
+
+	forwarder, ok := route.Forwarder.(*backend.Forwarder)
+	if !ok {
+		return fmt.Errorf("the route '%s' does not support to be updated", r.Name())
+	}
+
+	r.ForwarderConfig = r.ForwarderConfig.UnmaskSecrets(forwarder.Config())
+	if err = r.ForwarderConfig.Validate(); err != nil {
+		return
+	}
+
+	backends, err := r.Backends.Backends(r.Route)
+	if err != nil {
+		return
+	}
+
+	forwarder.SetBackends(backends...)
+	return forwarder.Update(r.ForwarderConfig)
+}
+
+// Define the actions and the types of the configuration change.
+const (
+	ChangeActionAdd    = "add"
+	ChangeActionUpdate = "update"
+	ChangeActionDelete = "delete"
+
+	ChangeTypeHost         = "host"
+	ChangeTypeBackendGroup = "backend_group"
+	ChangeTypeRoute        = "route"
+)
+
+// ConfigChange is a change between the current and desired configuration.
+type ConfigChange struct {
+	Action string `json:"action"`
+	Type   string `json:"type"`
+	Host   string `json:"host"`
+
+	// For the backend group
+	Name  string               `json:"name,omitempty"`
+	Group *backend.GroupConfig `json:"group,omitempty"`
+
+	// For the route
+	Path      string                   `json:"path,omitempty"`
+	Method    string                   `json:"method,omitempty"`
+	Plugins   []apigw.RoutePlugin      `json:"plugins,omitempty"`
+	Forwarder *backend.ForwarderConfig `json:"forwarder,omitempty"`
+
+	AddedBackends   backend.Backends `json:"added_backends,omitempty"`
+	DeletedBackends backend.Backends `json:"deleted_backends,omitempty"`
+}
+
+func (c ConfigChange) String() string {
+	switch c.Type {
+	case ChangeTypeHost:
+		return fmt.Sprintf("%s %s '%s'", c.Action, c.Type, c.Host)
+	case ChangeTypeBackendGroup:
+		return fmt.Sprintf("%s %s '%s' of the host '%s'", c.Action, c.Type, c.Name, c.Host)
+	default:
+		return fmt.Sprintf("%s %s '%s'", c.Action, c.Type,
+			apigw.NewRoute(c.Host, c.Path, c.Method).Name())
+	}
+}
+
+func backendConfigKey(b backend.Backend) string {
+	data, _ := json.Marshal(b)
+	return string(data)
+}
+
+// diffBackendConfigs returns the backends in news but not in olds,
+// and the ones in olds but not in news.
+func diffBackendConfigs(olds, news backend.Backends) (adds, dels backend.Backends) {
+	oldkeys := make(map[string]struct{}, len(olds))
+	for _, b := range olds {
+		oldkeys[backendConfigKey(b)] = struct{}{}
+	}
+
+	newkeys := make(map[string]struct{}, len(news))
+	for _, b := range news {
+		key := backendConfigKey(b)
+		newkeys[key] = struct{}{}
+		if _, ok := oldkeys[key]; !ok {
+			adds = append(adds, b)
+		}
+	}
+
+	for _, b := range olds {
+		if _, ok := newkeys[backendConfigKey(b)]; !ok {
+			dels = append(dels, b)
+		}
+	}
+
+	return
+}
+
+func routeConfigKey(r apigw.Route) string {
+	return strings.Join([]string{r.Host, r.Method, r.Path}, "@")
+}
+
+func groupConfigKey(bg BackendGroupConfig) string {
+	return strings.Join([]string{bg.Host, bg.Name}, "@")
+}
+
+// diffGatewayConfig returns the changes to converge the configuration
+// from current to desired, which are sorted in the order to be applied.
+func diffGatewayConfig(current, desired GatewayConfig) (changes []ConfigChange) {
+	// Add the hosts.
+	curhosts := make(map[string]struct{}, len(current.Hosts))
+	for _, host := range current.Hosts {
+		curhosts[host] = struct{}{}
+	}
+	deshosts := make(map[string]struct{}, len(desired.Hosts))
+	for _, host := range desired.Hosts {
+		deshosts[host] = struct{}{}
+		if _, ok := curhosts[host]; !ok {
+			changes = append(changes, ConfigChange{
+				Action: ChangeActionAdd,
+				Type:   ChangeTypeHost,
+				Host:   host,
+			})
+		}
+	}
+
+	// Add or update the backend groups.
+	curgroups := make(map[string]BackendGroupConfig, len(current.BackendGroups))
+	for _, bg := range current.BackendGroups {
+		curgroups[groupConfigKey(bg)] = bg
+	}
+	desgroups := make(map[string]struct{}, len(desired.BackendGroups))
+	for _, bg := range desired.BackendGroups {
+		key := groupConfigKey(bg)
+		desgroups[key] = struct{}{}
+		if cur, ok := curgroups[key]; !ok {
+			change := ConfigChange{
+				Action:        ChangeActionAdd,
+				Type:          ChangeTypeBackendGroup,
+				Host:          bg.Host,
+				Name:          bg.Name,
+				AddedBackends: bg.Backends,
+			}
+			if gc := bg.GroupConfig; gc != (backend.GroupConfig{}) {
+				change.Group = &gc
+			}
+			changes = append(changes, change)
+		} else if adds, dels := diffBackendConfigs(cur.Backends, bg.Backends); len(adds) > 0 ||
+			len(dels) > 0 || !reflect.DeepEqual(cur.GroupConfig, bg.GroupConfig) {
+			change := ConfigChange{
+				Action:          ChangeActionUpdate,
+				Type:            ChangeTypeBackendGroup,
+				Host:            bg.Host,
+				Name:            bg.Name,
+				AddedBackends:   adds,
+				DeletedBackends: dels,
+			}
+			if gc := bg.GroupConfig; !reflect.DeepEqual(cur.GroupConfig, gc) {
+				change.Group = &gc
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	// Add or update the routes.
+	curroutes := make(map[string]RouteConfig, len(current.Routes))
+	for _, r := range current.Routes {
+		curroutes[routeConfigKey(r.Route)] = r
+	}
+	desroutes := make(map[string]struct{}, len(desired.Routes))
+	for _, r := range desired.Routes {
+		key := routeConfigKey(r.Route)
+		desroutes[key] = struct{}{}
+
+		cur, ok := curroutes[key]
+		if ok && !equalRoutePlugins(cur.Plugins, r.Plugins) {
+			// The plugins cannot be updated, so re-register the route.
+			changes = append(changes, newRouteChange(ChangeActionDelete, cur, nil, nil))
+			ok = false
+		}
+
+		if !ok {
+			change := newRouteChange(ChangeActionAdd, r, r.Backends, nil)
+			if fc := r.ForwarderConfig; !fc.IsZero() {
+				change.Forwarder = &fc
+			}
+			changes = append(changes, change)
+		} else if adds, dels := diffBackendConfigs(cur.Backends, r.Backends); len(adds) > 0 ||
+			len(dels) > 0 || !cur.ForwarderConfig.Equal(r.ForwarderConfig) {
+			change := newRouteChange(ChangeActionUpdate, r, adds, dels)
+			if fc := r.ForwarderConfig; !cur.ForwarderConfig.Equal(fc) {
+				change.Forwarder = &fc
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	// Delete the routes.
+	for _, r := range current.Routes {
+		if _, ok := desroutes[routeConfigKey(r.Route)]; !ok {
+			changes = append(changes, newRouteChange(ChangeActionDelete, r, nil, nil))
+		}
+	}
+
+	// Delete the backend groups.
+	for _, bg := range current.BackendGroups {
+		if _, ok := desgroups[groupConfigKey(bg)]; !ok {
+			changes = append(changes, ConfigChange{
+				Action: ChangeActionDelete,
+				Type:   ChangeTypeBackendGroup,
+				Host:   bg.Host,
+				Name:   bg.Name,
+			})
+		}
+	}
+
+	// Delete the hosts.
+	for _, host := range current.Hosts {
+		if _, ok := deshosts[host]; !ok {
+			changes = append(changes, ConfigChange{
+				Action: ChangeActionDelete,
+				Type:   ChangeTypeHost,
+				Host:   host,
+			})
+		}
+	}
+
+	return
+}
+
+func equalRoutePlugins(ps1, ps2 []apigw.RoutePlugin) bool {
+	if len(ps1) == 0 && len(ps2) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(ps1, ps2)
+}
+
+func newRouteChange(action string, r RouteConfig, adds, dels backend.Backends) ConfigChange {
+	return ConfigChange{
+		Action:          action,
+		Type:            ChangeTypeRoute,
+		Host:            r.Host,
+		Path:            r.Path,
+		Method:          r.Method,
+		Plugins:         r.Plugins,
+		AddedBackends:   adds,
+		DeletedBackends: dels,
+	}
+}
+
+// validateGatewayConfig checks whether the configuration is valid
+// without changing the gateway.
+func validateGatewayConfig(conf GatewayConfig) (err error) {
+	hosts := make(map[string]struct{}, len(conf.Hosts))
+	for _, host := range conf.Hosts {
+		hosts[host] = struct{}{}
+	}
+
+	groups := make(map[string]struct{}, len(conf.BackendGroups))
+	for _, bg := range conf.BackendGroups {
+		if _, ok := hosts[bg.Host]; !ok {
+			return fmt.Errorf("no host '%s' for the backend group '%s'", bg.Host, bg.Name)
+		} else if bg.Name == "" {
+			return fmt.Errorf("the name of the backend group must not be empty")
+		} else if err = bg.GroupConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid backend group '%s': %v", bg.Name, err)
+		} else if err = validateBackendConfigs(bg.Host, bg.Backends, nil); err != nil {
+			return fmt.Errorf("invalid backend group '%s': %v", bg.Name, err)
+		}
+		groups[groupConfigKey(bg)] = struct{}{}
+	}
+
+	for _, r := range conf.Routes {
+		if _, ok := hosts[r.Host]; !ok {
+			return fmt.Errorf("no host '%s' for the route '%s'", r.Host, r.Name())
+		} else if r.Path == "" || r.Method == "" {
+			return fmt.Errorf("missing path or method for the route '%s'", r.Name())
+		} else if err = r.ForwarderConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid forwarder of the route '%s': %v", r.Name(), err)
+		} else if err = validateBackendConfigs(r.Host, r.Backends, groups); err != nil {
+			return fmt.Errorf("invalid route '%s': %v", r.Name(), err)
+		}
+	}
+
+	return
+}
+
+func validateBackendConfigs(host string, bs backend.Backends, groups map[string]struct{}) error {
+	for _, b := range bs {
+		if err := b.Validate(); err != nil {
+			return err
+		} else if b.Type != "group" {
+			continue
+		}
+
+		name, _ := b.Metadata["name"].(string)
+		if _, ok := groups[groupConfigKey(BackendGroupConfig{Host: host, Name: name})]; !ok {
+			return fmt.Errorf("no the backend group '%s' below the host '%s'", name, host)
+		}
+	}
+	return nil
+}
+
+// applyConfigChanges applies the changes into the gateway in turn.
+func applyConfigChanges(gw *lb.Gateway, changes []ConfigChange) (err error) {
+	for _, c := range changes {
+		if err = applyConfigChange(gw, c); err != nil {
+			return fmt.Errorf("fail to %s: %v", c.String(), err)
+		}
+	}
+	return
+}
+
+func applyConfigChange(gw *lb.Gateway, c ConfigChange) (err error) {
+	switch c.Type {
+	case ChangeTypeHost:
+		if c.Action == ChangeActionAdd {
+			return gw.AddHost(c.Host)
+		}
+		return gw.DelHost(c.Host)
+
+	case ChangeTypeBackendGroup:
+		m := gw.GetBackendGroupManager(c.Host)
+		if m == nil {
+			return apigw.ErrNoHost
+		}
+
+		switch c.Action {
+		case ChangeActionAdd:
+			bg := BackendGroupConfig{Host: c.Host, Name: c.Name, Backends: c.AddedBackends}
+			if c.Group != nil {
+				bg.GroupConfig = *c.Group
+			}
+			return addBackendGroup(gw, bg)
+
+		case ChangeActionDelete:
+			m.DelBackendGroupByName(c.Name)
+			return
+
+		default:
+			group := m.GetBackendGroup(c.Name)
+			if group == nil {
+				return fmt.Errorf("no backend group named '%s'", c.Name)
+			}
+
+			route := apigw.Route{Host: c.Host}
+			dels, err := c.DeletedBackends.Addrs(route)
+			if err != nil {
+				return err
+			}
+
+			adds, err := c.AddedBackends.Addrs(route)
+			if err != nil {
+				return err
+			}
+
+			// The backends with the same address are updated in place.
+			for _, backend := range lookupBackends(group.GetBackends(), dels, adds...) {
+				group.DelBackend(backend)
+			}
+
+			if c.Group != nil {
+				if err = backend.UpdateGroupConfig(group, *c.Group); err != nil {
+					return err
+				}
+			}
+
+			return addBackendGroup(gw, BackendGroupConfig{
+				Host:     c.Host,
+				Name:     c.Name,
+				Backends: c.AddedBackends,
+			})
+		}
+
+	default:
+		route := apigw.NewRoute(c.Host, c.Path, c.Method)
+		route.Plugins = c.Plugins
+
+		switch c.Action {
+		case ChangeActionAdd:
+			r := RouteConfig{Route: route, Backends: c.AddedBackends}
+			if c.Forwarder != nil {
+				r.ForwarderConfig = *c.Forwarder
+			}
+			return addRoute(gw, r)
+
+		case ChangeActionDelete:
+			_, err = gw.UnregisterRoute(route)
+			return
+
+		default:
+			forwarder, err := gw.GetRouteForwarder(c.Host, c.Path, c.Method)
+			if err != nil {
+				return err
+			}
+
+			dels, err := c.DeletedBackends.Addrs(route)
+			if err != nil {
+				return err
+			}
+
+			addrs, err := c.AddedBackends.Addrs(route)
+			if err != nil {
+				return err
+			}
+
+			adds, err := c.AddedBackends.Backends(route)
+			if err != nil {
+				return err
+			}
+
+			// The backends with the same address are updated in place.
+			forwarder.DelBackends(lookupBackends(forwarder.GetBackends(), dels, addrs...)...)
+			forwarder.AddBackends(adds...)
+
+			if c.Forwarder != nil {
+				f, ok := forwarder.(*backend.Forwarder)
+				if !ok {
+					return fmt.Errorf("the route does not support to be updated")
+				}
+				return f.Update(*c.Forwarder)
+			}
+			return nil
+		}
+	}
+}
+
+// Define the formats of the configuration document.
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+)
+
+func isYAMLContentType(ct string) bool {
+	switch ct {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeGatewayConfig encodes the configuration by the format.
+//
+// For YAML, the field names are the same as JSON, so the two formats are
+// interchangeable.
+func encodeGatewayConfig(conf GatewayConfig, format string) ([]byte, error) {
+	data, err := json.Marshal(conf)
+	if err != nil || format != ConfigFormatYAML {
+		return data, err
+	}
+
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// decodeGatewayConfig decodes the configuration by the format.
+func decodeGatewayConfig(data []byte, format string) (conf GatewayConfig, err error) {
+	if format == ConfigFormatYAML {
+		var v interface{}
+		if err = yaml.Unmarshal(data, &v); err != nil {
+			return
+		} else if data, err = json.Marshal(yamlToJSONValue(v)); err != nil {
+			return
+		}
+	}
+
+	err = json.Unmarshal(data, &conf)
+	return
+}
+
+// yamlToJSONValue converts the maps decoded by YAML, whose keys are
+// interface{}, to map[string]interface{}, so that it can be encoded by JSON.
+func yamlToJSONValue(v interface{}) interface{} {
+	switch vs := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vs))
+		for key, value := range vs {
+			m[fmt.Sprint(key)] = yamlToJSONValue(value)
+		}
+		return m
+
+	case []interface{}:
+		for i, value := range vs {
+			vs[i] = yamlToJSONValue(value)
+		}
+		return vs
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xgfone/apigateway/backend"
+	"github.com/xgfone/apigw"
+	"github.com/xgfone/apigw/forward/lb"
+)
+
+func newTestBackend(url string) backend.Backend {
+	return backend.Backend{Type: "http", Metadata: map[string]interface{}{"url": url}}
+}
+
+func newTestRoute(host, path string, urls ...string) RouteConfig {
+	r := RouteConfig{Route: apigw.NewRoute(host, path, "GET")}
+	for _, url := range urls {
+		r.Backends = append(r.Backends, newTestBackend(url))
+	}
+	return r
+}
+
+func getChangeStrings(changes []ConfigChange) []string {
+	ss := make([]string, len(changes))
+	for i, c := range changes {
+		ss[i] = c.String()
+	}
+	return ss
+}
+
+func TestDiffGatewayConfig(t *testing.T) {
+	const url1, url2 = "http://127.0.0.1:8001", "http://127.0.0.1:8002"
+	base := GatewayConfig{
+		Hosts: []string{"www.example.com"},
+		BackendGroups: []BackendGroupConfig{{
+			Host:     "www.example.com",
+			Name:     "group",
+			Backends: backend.Backends{newTestBackend(url1)},
+		}},
+		Routes: []RouteConfig{newTestRoute("www.example.com", "/path", url1)},
+	}
+
+	tests := []struct {
+		name    string
+		current GatewayConfig
+		desired GatewayConfig
+		changes []string
+	}{
+		{"empty", GatewayConfig{}, GatewayConfig{}, []string{}},
+		{"unchanged", base, base, []string{}},
+		{
+			name:    "add",
+			desired: base,
+			changes: []string{
+				"add host 'www.example.com'",
+				"add backend_group 'group' of the host 'www.example.com'",
+				"add route 'www.example.com@GET@/path'",
+			},
+		},
+		{
+			name:    "delete",
+			current: base,
+			changes: []string{
+				"delete route 'www.example.com@GET@/path'",
+				"delete backend_group 'group' of the host 'www.example.com'",
+				"delete host 'www.example.com'",
+			},
+		},
+		{
+			name:    "update backends",
+			current: base,
+			desired: GatewayConfig{
+				Hosts: base.Hosts,
+				BackendGroups: []BackendGroupConfig{{
+					Host:     "www.example.com",
+					Name:     "group",
+					Backends: backend.Backends{newTestBackend(url2)},
+				}},
+				Routes: []RouteConfig{newTestRoute("www.example.com", "/path", url1, url2)},
+			},
+			changes: []string{
+				"update backend_group 'group' of the host 'www.example.com'",
+				"update route 'www.example.com@GET@/path'",
+			},
+		},
+		{
+			name:    "update forwarder",
+			current: base,
+			desired: GatewayConfig{
+				Hosts:         base.Hosts,
+				BackendGroups: base.BackendGroups,
+				Routes: []RouteConfig{func() RouteConfig {
+					r := newTestRoute("www.example.com", "/path", url1)
+					r.MaxTimeout = "10s"
+					return r
+				}()},
+			},
+			changes: []string{"update route 'www.example.com@GET@/path'"},
+		},
+		{
+			name:    "re-register the route with the changed plugins",
+			current: base,
+			desired: GatewayConfig{
+				Hosts:         base.Hosts,
+				BackendGroups: base.BackendGroups,
+				Routes: []RouteConfig{func() RouteConfig {
+					r := newTestRoute("www.example.com", "/path", url1)
+					r.Plugins = []apigw.RoutePlugin{{Name: "plugin"}}
+					return r
+				}()},
+			},
+			changes: []string{
+				"delete route 'www.example.com@GET@/path'",
+				"add route 'www.example.com@GET@/path'",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		changes := getChangeStrings(diffGatewayConfig(tt.current, tt.desired))
+		if !reflect.DeepEqual(changes, tt.changes) {
+			t.Errorf("%s: expect the changes %q, but got %q", tt.name, tt.changes, changes)
+		}
+	}
+}
+
+func TestApplyConfigChanges(t *testing.T) {
+	const url1, url2 = "http://127.0.0.1:8001", "http://127.0.0.1:8002"
+	configs := []GatewayConfig{
+		{
+			Hosts: []string{"www.example.com"},
+			BackendGroups: []BackendGroupConfig{{
+				Host:     "www.example.com",
+				Name:     "group",
+				Backends: backend.Backends{newTestBackend(url1)},
+			}},
+			Routes: []RouteConfig{newTestRoute("www.example.com", "/path1", url1)},
+		},
+		{
+			Hosts: []string{"www.example.com"},
+			BackendGroups: []BackendGroupConfig{{
+				Host:     "www.example.com",
+				Name:     "group",
+				Backends: backend.Backends{newTestBackend(url1), newTestBackend(url2)},
+			}},
+			Routes: []RouteConfig{
+				newTestRoute("www.example.com", "/path1", url2),
+				newTestRoute("www.example.com", "/path2", url1, url2),
+			},
+		},
+		{Hosts: []string{"www.example.com", "www.example.org"}},
+		{},
+	}
+
+	gw := lb.NewGateway()
+	for i, conf := range configs {
+		changes := diffGatewayConfig(exportGatewayConfig(gw), conf)
+		if err := applyConfigChanges(gw, changes); err != nil {
+			t.Fatalf("%d: fail to apply the changes: %v", i, err)
+		}
+
+		// The configuration has converged, so there is no change any more.
+		if changes := diffGatewayConfig(exportGatewayConfig(gw), conf); len(changes) > 0 {
+			t.Errorf("%d: expect no changes, but got %q", i, getChangeStrings(changes))
+		}
+	}
+}
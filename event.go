@@ -0,0 +1,175 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xgfone/apigateway/backend"
+	"github.com/xgfone/gconf/v5"
+	"github.com/xgfone/go-service/loadbalancer"
+)
+
+var eventOpts = []gconf.Opt{
+	gconf.IntOpt("maxevents", "The maximum number of the recent events kept to be resumed.").D(1000),
+}
+
+func init() { gconf.RegisterOpts(eventOpts...) }
+
+// Define the types of the event.
+const (
+	EventTypeConfig = "config"
+	EventTypeHealth = "health"
+
+	// EventTypeReset represents that some events have been missed,
+	// so the client should fetch the whole state again.
+	EventTypeReset = "reset"
+)
+
+// HealthEvent is the health transition of the endpoint.
+type HealthEvent struct {
+	Endpoint string `json:"endpoint"`
+	Type     string `json:"type"`
+	Online   bool   `json:"online"`
+}
+
+// Event is the event of the configuration change or the health transition.
+type Event struct {
+	ID     uint64        `json:"id"`
+	Time   time.Time     `json:"time"`
+	Type   string        `json:"type"`
+	Change *ConfigChange `json:"change,omitempty"`
+	Health *HealthEvent  `json:"health,omitempty"`
+}
+
+// eventSubscriber is the subscriber of the events, whose channel will be
+// closed if it falls behind.
+type eventSubscriber struct {
+	events chan Event
+}
+
+// eventBus keeps a bounded history of the events and dispatches the new
+// events to the subscribers.
+type eventBus struct {
+	lock    sync.RWMutex
+	lastID  uint64
+	maxsize int
+	events  []Event
+	subs    map[*eventSubscriber]struct{}
+}
+
+var events = &eventBus{maxsize: 1000, subs: make(map[*eventSubscriber]struct{})}
+
+// Publish publishes the event, which never blocks.
+func (b *eventBus) Publish(e Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.events = append(b.events, e)
+	if _len := len(b.events); b.maxsize > 0 && _len > b.maxsize {
+		b.events = append([]Event{}, b.events[_len-b.maxsize:]...)
+	}
+
+	for sub := range b.subs {
+		select {
+		case sub.events <- e:
+		default: // The subscriber falls behind, so disconnect it to resume.
+			delete(b.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe subscribes the events after the event identified by lastID,
+// and returns the missed events and the subscriber.
+//
+// If lastID is ZERO, only subscribe the new events. If some events after
+// lastID have been discarded, reset is true and no missed events are returned.
+func (b *eventBus) Subscribe(lastID uint64) (missed []Event, reset bool, sub *eventSubscriber) {
+	sub = &eventSubscriber{events: make(chan Event, 256)}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if lastID > 0 {
+		switch {
+		case lastID > b.lastID:
+			reset = true
+		case len(b.events) == 0 || lastID < b.events[0].ID-1:
+			reset = lastID < b.lastID
+		default:
+			for _, e := range b.events {
+				if e.ID > lastID {
+					missed = append(missed, e)
+				}
+			}
+		}
+	}
+
+	b.subs[sub] = struct{}{}
+	return
+}
+
+// Unsubscribe unsubscribes the events.
+func (b *eventBus) Unsubscribe(sub *eventSubscriber) {
+	b.lock.Lock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+	b.lock.Unlock()
+}
+
+// publishConfigChanges publishes the configuration changes as the events,
+// the secrets of which are masked.
+func publishConfigChanges(changes []ConfigChange) {
+	changes = maskConfigChanges(changes)
+	for i := range changes {
+		events.Publish(Event{Type: EventTypeConfig, Change: &changes[i]})
+	}
+}
+
+// healthEventUpdater publishes the health transitions of the endpoints
+// of the global health checker.
+type healthEventUpdater struct{}
+
+func (u healthEventUpdater) Name() string { return "event" }
+func (u healthEventUpdater) AddEndpoint(ep loadbalancer.Endpoint) {
+	u.publish(ep, true)
+}
+func (u healthEventUpdater) DelEndpoint(ep loadbalancer.Endpoint) {
+	u.publish(ep, false)
+}
+func (u healthEventUpdater) publish(ep loadbalancer.Endpoint, online bool) {
+	events.Publish(Event{Type: EventTypeHealth, Health: &HealthEvent{
+		Endpoint: ep.String(),
+		Type:     ep.Type(),
+		Online:   online,
+	}})
+}
+
+// initEvents initializes the event bus and subscribes the health
+// transitions of all the endpoints.
+func initEvents() {
+	events.maxsize = gconf.MustInt("maxevents")
+	backend.HC.Subscribe("", healthEventUpdater{})
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventBusSubscribe(t *testing.T) {
+	bus := &eventBus{maxsize: 3, subs: make(map[*eventSubscriber]struct{})}
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Type: EventTypeHealth})
+	}
+
+	tests := []struct {
+		lastID uint64
+		missed []uint64
+		reset  bool
+	}{
+		{0, nil, false},
+		{5, nil, false},
+		{4, []uint64{5}, false},
+		{2, []uint64{3, 4, 5}, false},
+		{1, nil, true},
+		{6, nil, true},
+	}
+
+	for _, tt := range tests {
+		missed, reset, sub := bus.Subscribe(tt.lastID)
+		bus.Unsubscribe(sub)
+
+		var ids []uint64
+		for _, e := range missed {
+			ids = append(ids, e.ID)
+		}
+
+		if reset != tt.reset {
+			t.Errorf("%d: expect reset %v, but got %v", tt.lastID, tt.reset, reset)
+		}
+		if !reflect.DeepEqual(ids, tt.missed) {
+			t.Errorf("%d: expect the missed events %v, but got %v", tt.lastID, tt.missed, ids)
+		}
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := &eventBus{subs: make(map[*eventSubscriber]struct{})}
+	_, _, sub := bus.Subscribe(0)
+
+	num := cap(sub.events) + 1
+	for i := 0; i < num; i++ {
+		bus.Publish(Event{Type: EventTypeHealth})
+	}
+
+	var count int
+	for range sub.events {
+		count++
+	}
+	if count != cap(sub.events) {
+		t.Errorf("expect %d events before disconnected, but got %d", cap(sub.events), count)
+	}
+
+	if len(bus.subs) != 0 {
+		t.Errorf("the slow subscriber is not unsubscribed")
+	}
+	bus.Unsubscribe(sub) // Unsubscribing it again does not panic.
+}
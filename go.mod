@@ -7,7 +7,10 @@ require (
 	github.com/xgfone/go-service v0.14.0
 	github.com/xgfone/go-tools/v7 v7.6.0
 	github.com/xgfone/goapp v0.18.0
+	github.com/xgfone/klog/v4 v4.1.0
 	github.com/xgfone/ship/v3 v3.11.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	gopkg.in/yaml.v2 v2.3.0
 )
 
-go 1.11
+go 1.15
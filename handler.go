@@ -15,6 +15,14 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/xgfone/apigateway/audit"
+	"github.com/xgfone/apigateway/auth"
 	"github.com/xgfone/apigateway/backend"
 	"github.com/xgfone/apigw"
 	"github.com/xgfone/apigw/forward/lb"
@@ -29,10 +37,17 @@ var routeOpts = []gconf.Opt{
 func init() { gconf.RegisterOpts(routeOpts...) }
 
 func initAdminRouter(r *ship.Ship) {
-	backend.DefaultForwarderMaxTimeout = gconf.MustDuration("maxtimeout")
 	c := adminController{}
 
-	v1admin := r.Group("/v1/admin")
+	v1admin := r.Group("/v1/admin").Use(auditAdminUpdate, recordConfigUpdate)
+	v1admin.Route("/audit").GET(c.GetAuditRecords)
+	v1admin.Route("/events").GET(c.GetEvents)
+	v1admin.Route("/config").
+		GET(c.GetConfig).
+		PUT(c.ApplyConfig)
+	v1admin.Route("/config/revision").GET(c.GetConfigRevisions)
+	v1admin.Route("/config/revision/diff").GET(c.DiffConfigRevisions)
+	v1admin.Route("/config/revision/rollback").POST(c.RollbackConfigRevision)
 	v1admin.Route("/host").
 		GET(c.GetAllDomains).
 		POST(c.CreateDomain).
@@ -40,6 +55,7 @@ func initAdminRouter(r *ship.Ship) {
 	v1admin.Route("/host/route").
 		GET(c.GetAllDomainRoutes).
 		POST(c.AddDomainRoute).
+		PUT(c.UpdateDomainRoute).
 		DELETE(c.DelDomainRoute)
 	v1admin.Route("/host/route/backend").
 		GET(c.GetAllDomainRouteBackends).
@@ -50,10 +66,21 @@ func initAdminRouter(r *ship.Ship) {
 		POST(c.CreateBackendGroup).
 		DELETE(c.DeleteBackendGroup)
 
+	v1admin.Route("/healthcheck").
+		GET(c.GetHealthCheckSettings).
+		PUT(c.UpdateHealthCheckSettings)
 	v1adminUnderlying := v1admin.Group("/underlying")
 	v1adminUnderlying.Route("/hosts").GET(c.GetAllUnderlyingHosts)
 	v1adminUnderlying.Route("/routes").GET(c.GetAllUnderlyingRoutes)
 	v1adminUnderlying.Route("/endpoints").GET(c.GetAllUnderlyingEndpoints)
+	v1adminUnderlying.Route("/endpoints/history").GET(c.GetEndpointHistory)
+	v1adminUnderlying.Route("/endpoints/override").
+		GET(c.GetEndpointOverrides).
+		PUT(c.SetEndpointOverride).
+		DELETE(c.DelEndpointOverride)
+
+	v1admin.Route("/openapi.json").GET(c.GetOpenAPIDocument)
+	initAdminAPIDocument(r)
 }
 
 type adminController struct{}
@@ -73,21 +100,333 @@ func (c adminController) sendError(host, path, method string, err error) error {
 	}
 }
 
+// authorize checks whether the caller has the role on the host.
+func (c adminController) authorize(ctx *ship.Context, role auth.Role, host string) error {
+	if caller := auth.GetCaller(ctx); !rbac.Allow(caller, role, host) {
+		return ship.ErrForbidden.Newf("the caller '%s' is not %s of the host '%s'", caller, role, host)
+	}
+	return nil
+}
+
+// authorizeAll checks whether the caller has the role on all the hosts.
+func (c adminController) authorizeAll(ctx *ship.Context, role auth.Role) error {
+	if caller := auth.GetCaller(ctx); !rbac.AllowAll(caller, role) {
+		return ship.ErrForbidden.Newf("the caller '%s' is not %s of all the hosts", caller, role)
+	}
+	return nil
+}
+
+// allowHost returns a function to report whether the caller has the role on the host.
+func (c adminController) allowHost(ctx *ship.Context, role auth.Role) func(string) bool {
+	caller := auth.GetCaller(ctx)
+	return func(host string) bool { return rbac.Allow(caller, role, host) }
+}
+
+func (c adminController) GetOpenAPIDocument(ctx *ship.Context) (err error) {
+	return ctx.JSON(200, adminAPIDocument)
+}
+
+func (c adminController) GetConfig(ctx *ship.Context) (err error) {
+	var req GetConfigRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+
+	conf := exportGatewayConfig(lb.DefaultGateway)
+	if !rbac.AllowAll(auth.GetCaller(ctx), auth.RoleViewer) {
+		conf = filterGatewayConfig(conf, c.allowHost(ctx, auth.RoleViewer))
+	}
+
+	conf = maskGatewayConfig(conf)
+	switch req.Format {
+	case "", ConfigFormatJSON:
+		return ctx.JSON(200, conf)
+	case ConfigFormatYAML:
+		data, err := encodeGatewayConfig(conf, req.Format)
+		if err != nil {
+			return ship.ErrInternalServerError.New(err)
+		}
+		return ctx.Blob(200, "application/yaml; charset=UTF-8", data)
+	default:
+		return ship.ErrBadRequest.Newf("unknown format '%s'", req.Format)
+	}
+}
+
+func (c adminController) ApplyConfig(ctx *ship.Context) (err error) {
+	var req ApplyConfigRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleHostAdmin); err != nil {
+		return
+	}
+
+	var conf GatewayConfig
+	if isYAMLContentType(ctx.ContentType()) {
+		var body string
+		if body, err = ctx.GetBody(); err == nil {
+			conf, err = decodeGatewayConfig([]byte(body), ConfigFormatYAML)
+		}
+	} else {
+		err = ctx.Bind(&conf)
+	}
+
+	if err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+
+	current := exportGatewayConfig(lb.DefaultGateway)
+	conf = unmaskGatewayConfig(conf, current)
+	if err = validateGatewayConfig(conf); err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+
+	changes := diffGatewayConfig(current, conf)
+	if !req.DryRun {
+		if err = applyConfigChanges(lb.DefaultGateway, changes); err != nil {
+			return ship.ErrInternalServerError.New(err)
+		}
+	}
+
+	changes = maskConfigChanges(changes)
+	return ctx.JSON(200, ApplyConfigResponse{DryRun: req.DryRun, Changes: changes})
+}
+
+func (c adminController) GetConfigRevisions(ctx *ship.Context) (err error) {
+	var req GetConfigRevisionsRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
+	}
+
+	if req.ID == 0 {
+		return ctx.JSON(200, ConfigRevisionsResponse{Revisions: revisions.GetAll()})
+	}
+
+	rev, ok := revisions.Get(req.ID)
+	if !ok {
+		return ship.ErrBadRequest.Newf("no revision '%d'", req.ID)
+	}
+
+	conf := maskGatewayConfig(*rev.Config)
+	rev.Config = &conf
+	return ctx.JSON(200, rev)
+}
+
+func (c adminController) DiffConfigRevisions(ctx *ship.Context) (err error) {
+	var req DiffConfigRevisionsRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
+	}
+
+	from, ok := revisions.Get(req.From)
+	if !ok {
+		return ship.ErrBadRequest.Newf("no revision '%d'", req.From)
+	}
+
+	var to GatewayConfig
+	if req.To == 0 {
+		to = exportGatewayConfig(lb.DefaultGateway)
+	} else if rev, ok := revisions.Get(req.To); !ok {
+		return ship.ErrBadRequest.Newf("no revision '%d'", req.To)
+	} else {
+		to = *rev.Config
+	}
+
+	changes := maskConfigChanges(diffGatewayConfig(*from.Config, to))
+	return ctx.JSON(200, ConfigChangesResponse{Changes: changes})
+}
+
+func (c adminController) RollbackConfigRevision(ctx *ship.Context) (err error) {
+	var req RollbackConfigRevisionRequest
+	if err = ctx.Bind(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleHostAdmin); err != nil {
+		return
+	}
+
+	rev, ok := revisions.Get(req.ID)
+	if !ok {
+		return ship.ErrBadRequest.Newf("no revision '%d'", req.ID)
+	}
+
+	changes, err := rollbackGatewayConfig(lb.DefaultGateway, *rev.Config)
+	if err != nil {
+		return ship.ErrInternalServerError.New(err)
+	}
+	return ctx.JSON(200, ConfigChangesResponse{Changes: maskConfigChanges(changes)})
+}
+
+func (c adminController) GetAuditRecords(ctx *ship.Context) (err error) {
+	var req GetAuditRecordsRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
+	}
+
+	filter := audit.Filter{
+		Caller: req.Caller,
+		Method: req.Method,
+		Path:   req.Path,
+		Result: req.Result,
+		Limit:  req.Limit,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+	if req.Since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, req.Since); err != nil {
+			return ship.ErrBadRequest.New(err)
+		}
+	}
+	if req.Until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, req.Until); err != nil {
+			return ship.ErrBadRequest.New(err)
+		}
+	}
+
+	return ctx.JSON(200, AuditRecordsResponse{Records: auditor.Query(filter)})
+}
+
+func (c adminController) GetEvents(ctx *ship.Context) (err error) {
+	var req GetEventsRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+		if req.LastEventID, err = strconv.ParseUint(id, 10, 64); err != nil {
+			return ship.ErrBadRequest.New(err)
+		}
+	}
+
+	allowHost := c.allowHost(ctx, auth.RoleViewer)
+	allowHealth := rbac.AllowAll(auth.GetCaller(ctx), auth.RoleViewer)
+	allow := func(e Event) bool {
+		switch {
+		case e.Change != nil:
+			return allowHost(e.Change.Host)
+		case e.Health != nil:
+			return allowHealth
+		default:
+			return true
+		}
+	}
+
+	missed, reset, sub := events.Subscribe(req.LastEventID)
+	defer events.Unsubscribe(sub)
+
+	res := ctx.Response()
+	ctx.SetHeader(ship.HeaderContentType, "text/event-stream")
+	ctx.SetHeader("Cache-Control", "no-cache")
+	ctx.SetHeader("X-Accel-Buffering", "no")
+	res.WriteHeader(200)
+
+	write := func(e Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err
+	}
+
+	if reset {
+		if _, err = fmt.Fprintf(res, "event: %s\ndata: {}\n\n", EventTypeReset); err != nil {
+			return nil
+		}
+	}
+	for _, e := range missed {
+		if allow(e) {
+			if err = write(e); err != nil {
+				return nil
+			}
+		}
+	}
+	res.Flush()
+
+	ticker := time.NewTicker(time.Second * 15)
+	defer ticker.Stop()
+
+	done := ctx.Request().Context().Done()
+	for {
+		select {
+		case <-done:
+			return nil
+
+		case <-ticker.C:
+			if _, err = io.WriteString(res, ":keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+
+		case e, ok := <-sub.events:
+			if !ok { // Fall behind, and the client should reconnect to resume.
+				return nil
+			} else if allow(e) {
+				if err = write(e); err != nil {
+					return nil
+				}
+				res.Flush()
+			}
+		}
+	}
+}
+
+func newHealthCheckSettings(c backend.HealthCheckConfig) HealthCheckSettings {
+	return HealthCheckSettings{
+		Interval:    c.Interval.String(),
+		Timeout:     c.Timeout.String(),
+		RetryNum:    c.RetryNum,
+		Concurrency: c.Concurrency,
+	}
+}
+
+func (c adminController) GetHealthCheckSettings(ctx *ship.Context) (err error) {
+	if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
+	}
+	return ctx.JSON(200, newHealthCheckSettings(backend.GetHealthCheckConfig()))
+}
+
+func (c adminController) UpdateHealthCheckSettings(ctx *ship.Context) (err error) {
+	req := newHealthCheckSettings(backend.GetHealthCheckConfig())
+	if err = ctx.Bind(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleHostAdmin); err != nil {
+		return
+	}
+
+	conf := backend.HealthCheckConfig{RetryNum: req.RetryNum, Concurrency: req.Concurrency}
+	if conf.Interval, err = time.ParseDuration(req.Interval); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if conf.Timeout, err = time.ParseDuration(req.Timeout); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = backend.SetHealthCheckConfig(conf); err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+	return
+}
+
 func (c adminController) GetAllUnderlyingHosts(ctx *ship.Context) (err error) {
+	allow := c.allowHost(ctx, auth.RoleViewer)
 	routers := lb.DefaultGateway.Router().Routers()
 	hosts := make([]string, 0, len(routers))
 	for host := range routers {
-		hosts = append(hosts, host)
+		if allow(host) {
+			hosts = append(hosts, host)
+		}
 	}
-	return ctx.JSON(200, map[string][]string{"hosts": hosts})
+	return ctx.JSON(200, HostsResponse{Hosts: hosts})
 }
 
 func (c adminController) GetAllUnderlyingRoutes(ctx *ship.Context) (err error) {
-	var req struct {
-		Host string `query:"host" validate:"zero|hostname_rfc1123"`
-	}
+	var req HostQueryRequest
 	if err = ctx.BindQuery(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleViewer, req.Host); err != nil {
+		return
 	}
 
 	var routes []apigw.Route
@@ -99,33 +438,96 @@ func (c adminController) GetAllUnderlyingRoutes(ctx *ship.Context) (err error) {
 		}
 	}
 
-	return ctx.JSON(200, map[string][]apigw.Route{"routes": routes})
+	return ctx.JSON(200, RoutesResponse{Routes: routes})
 }
 
 func (c adminController) GetAllUnderlyingEndpoints(ctx *ship.Context) (err error) {
+	if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
+	}
+
 	endpoints := backend.HC.Endpoints()
-	eps := make([]map[string]interface{}, len(endpoints))
+	eps := make([]EndpointInfo, len(endpoints))
 	for i, _len := 0, len(endpoints); i < _len; i++ {
 		ep := endpoints[i]
 		metadata := ep.MetaData()
-		metadata["online"] = backend.HC.IsHealthy(ep.String())
-		eps[i] = map[string]interface{}{
-			"type":            ep.Type(),
-			"userdata":        ep.UserData(),
-			"metadata":        metadata,
-			"reference_count": backend.HC.ReferenceCount(ep.String()),
+		metadata["online"] = backend.IsEndpointOnline(ep.String())
+		metadata["healthy"] = backend.HC.IsHealthy(ep.String())
+		eps[i] = EndpointInfo{
+			Type:           ep.Type(),
+			UserData:       ep.UserData(),
+			MetaData:       metadata,
+			ReferenceCount: backend.HC.ReferenceCount(ep.String()),
+			Drains:         backend.GetDrainStates(ep.String()),
+			Ejections:      backend.GetEjectionStates(ep.String()),
+			Breakers:       backend.GetCircuitBreakerStates(ep.String()),
+			Override:       backend.GetEndpointOverride(ep.String()),
+		}
+		if pool, ok := backend.GetPoolStats(ep.String()); ok {
+			eps[i].Pool = &pool
 		}
 	}
-	return ctx.JSON(200, map[string]interface{}{"endpoints": eps})
+	return ctx.JSON(200, EndpointsResponse{Endpoints: eps})
 }
 
-func (c adminController) GetBackendGroup(ctx *ship.Context) (err error) {
-	var req struct {
-		Host         string `query:"host" validate:"zero|hostname_rfc1123"`
-		BackendGroup string `query:"backend_group"`
+func (c adminController) GetEndpointHistory(ctx *ship.Context) (err error) {
+	var req EndpointHistoryRequest
+	if err = ctx.BindQuery(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
 	}
+
+	history, ok := backend.GetEndpointHistory(req.Endpoint)
+	if !ok {
+		return ship.ErrBadRequest.Newf("no endpoint '%s'", req.Endpoint)
+	}
+	return ctx.JSON(200, history)
+}
+
+func (c adminController) GetEndpointOverrides(ctx *ship.Context) (err error) {
+	if err = c.authorizeAll(ctx, auth.RoleViewer); err != nil {
+		return
+	}
+	return ctx.JSON(200, EndpointOverridesResponse{Overrides: backend.GetEndpointOverrides()})
+}
+
+func (c adminController) SetEndpointOverride(ctx *ship.Context) (err error) {
+	var req EndpointOverrideRequest
+	if err = ctx.Bind(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleHostAdmin); err != nil {
+		return
+	} else if req.State == "" {
+		return ship.ErrBadRequest.Newf("missing the administrative state")
+	} else if !backend.HC.HasEndpoint(req.Endpoint) {
+		return ship.ErrBadRequest.Newf("no endpoint '%s'", req.Endpoint)
+	}
+
+	if err = backend.SetEndpointOverride(req.Endpoint, req.State); err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+	return
+}
+
+func (c adminController) DelEndpointOverride(ctx *ship.Context) (err error) {
+	var req EndpointOverrideRequest
+	if err = ctx.Bind(&req); err != nil {
+		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorizeAll(ctx, auth.RoleHostAdmin); err != nil {
+		return
+	}
+
+	backend.SetEndpointOverride(req.Endpoint, "")
+	return
+}
+
+func (c adminController) GetBackendGroup(ctx *ship.Context) (err error) {
+	var req GetBackendGroupRequest
 	if err = ctx.BindQuery(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleViewer, req.Host); err != nil {
+		return
 	}
 
 	m := lb.DefaultGateway.GetBackendGroupManager(req.Host)
@@ -139,7 +541,7 @@ func (c adminController) GetBackendGroup(ctx *ship.Context) (err error) {
 		for i, _len := 0, len(bgs); i < _len; i++ {
 			gs[i] = bgs[i].Name()
 		}
-		return ctx.JSON(200, map[string]interface{}{"backend_groups": gs})
+		return ctx.JSON(200, BackendGroupNamesResponse{BackendGroups: gs})
 	}
 
 	bg := m.GetBackendGroup(req.BackendGroup)
@@ -163,6 +565,7 @@ func (c adminController) GetBackendGroup(ctx *ship.Context) (err error) {
 
 		bs[i] = backend.Backend{
 			Type:     b.Type(),
+			Weight:   backend.NewBackend(b).Weight,
 			Metadata: b.MetaData(),
 			RetryNum: hc.RetryNum,
 			Interval: interval,
@@ -176,19 +579,19 @@ func (c adminController) GetBackendGroup(ctx *ship.Context) (err error) {
 		forwarders[i] = updaters[i].Name()
 	}
 
-	return ctx.JSON(200, map[string]interface{}{"backends": bs, "forwarders": forwarders})
+	return ctx.JSON(200, BackendGroupResponse{
+		GroupConfig: backend.GetGroupConfig(bg),
+		Backends:    bs,
+		Forwarders:  forwarders,
+	})
 }
 
 func (c adminController) CreateBackendGroup(ctx *ship.Context) (err error) {
-	var req struct {
-		Host          string `json:"host" validate:"zero|hostname_rfc1123"`
-		BackendGroups []struct {
-			Name     string           `json:"name" validate:"required"`
-			Backends backend.Backends `json:"backends"`
-		} `json:"backend_groups"`
-	}
+	var req BackendGroupsRequest
 	if err = ctx.Bind(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleHostAdmin, req.Host); err != nil {
+		return
 	}
 
 	m := lb.DefaultGateway.GetBackendGroupManager(req.Host)
@@ -196,32 +599,27 @@ func (c adminController) CreateBackendGroup(ctx *ship.Context) (err error) {
 		return ship.ErrBadRequest.Newf("no host '%s'", req.Host)
 	}
 
-	conf := &lb.GroupBackendConfig{IsHealthy: backend.IsHealthy}
 	for _, bg := range req.BackendGroups {
-		backends, err := bg.Backends.Backends(apigw.Route{Host: req.Host})
+		err = addBackendGroup(lb.DefaultGateway, BackendGroupConfig{
+			Host:        req.Host,
+			Name:        bg.Name,
+			GroupConfig: bg.GroupConfig,
+			Backends:    bg.Backends,
+		})
 		if err != nil {
 			return ship.ErrBadRequest.New(err)
 		}
-
-		group := m.AddOrNewBackendGroup(bg.Name, conf)
-		for _, backend := range backends {
-			group.AddBackend(backend)
-		}
 	}
 
 	return
 }
 
 func (c adminController) DeleteBackendGroup(ctx *ship.Context) (err error) {
-	var req struct {
-		Host          string `json:"host" validate:"zero|hostname_rfc1123"`
-		BackendGroups []struct {
-			Name     string           `json:"name" validate:"required"`
-			Backends backend.Backends `json:"backends"`
-		} `json:"backend_groups"`
-	}
+	var req BackendGroupsRequest
 	if err = ctx.Bind(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleHostAdmin, req.Host); err != nil {
+		return
 	}
 
 	m := lb.DefaultGateway.GetBackendGroupManager(req.Host)
@@ -229,12 +627,25 @@ func (c adminController) DeleteBackendGroup(ctx *ship.Context) (err error) {
 		return ship.ErrBadRequest.Newf("no host '%s'", req.Host)
 	}
 
+	drain, err := parseDrain(req.Drain)
+	if err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+
 	for _, bg := range req.BackendGroups {
+		g := m.GetBackendGroup(bg.Name)
 		if len(bg.Backends) == 0 {
+			if g != nil && drain >= 0 {
+				backend.DrainGroupBackends(g, drain, g.GetBackends()...)
+			}
 			m.DelBackendGroupByName(bg.Name)
-		} else if backends, err := bg.Backends.Backends(apigw.Route{Host: req.Host}); err != nil {
+		} else if addrs, err := bg.Backends.Addrs(apigw.Route{Host: req.Host}); err != nil {
 			return ship.ErrBadRequest.New(err)
-		} else if g := m.GetBackendGroup(bg.Name); g != nil {
+		} else if g == nil {
+			continue
+		} else if backends := lookupBackends(g.GetBackends(), addrs); drain >= 0 {
+			backend.DrainGroupBackends(g, drain, backends...)
+		} else {
 			for _, backend := range backends {
 				g.DelBackend(backend)
 			}
@@ -245,68 +656,92 @@ func (c adminController) DeleteBackendGroup(ctx *ship.Context) (err error) {
 }
 
 func (c adminController) GetAllDomains(ctx *ship.Context) (err error) {
-	return ctx.JSON(200, map[string][]string{"hosts": lb.DefaultGateway.GetHosts()})
+	allow := c.allowHost(ctx, auth.RoleViewer)
+	hosts := lb.DefaultGateway.GetHosts()
+	allowed := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if allow(host) {
+			allowed = append(allowed, host)
+		}
+	}
+	return ctx.JSON(200, HostsResponse{Hosts: allowed})
 }
 
 func (c adminController) CreateDomain(ctx *ship.Context) (err error) {
-	var req struct {
-		Host string `json:"host" validate:"zero|hostname_rfc1123"`
-	}
+	var req HostRequest
 	if err = ctx.Bind(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleHostAdmin, req.Host); err != nil {
+		return
 	}
 	return lb.DefaultGateway.AddHost(req.Host)
 }
 
 func (c adminController) DeleteDomain(ctx *ship.Context) (err error) {
-	var req struct {
-		Host string `query:"host" validate:"zero|hostname_rfc1123"`
-	}
+	var req HostQueryRequest
 	if err = ctx.BindQuery(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleHostAdmin, req.Host); err != nil {
+		return
 	}
 	return lb.DefaultGateway.DelHost(req.Host)
 }
 
 func (c adminController) GetAllDomainRoutes(ctx *ship.Context) (err error) {
-	var req struct {
-		Host string `query:"host" validate:"zero|hostname_rfc1123"`
-	}
+	var req HostQueryRequest
 	if err = ctx.BindQuery(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleViewer, req.Host); err != nil {
+		return
 	}
 
 	routes, err := lb.DefaultGateway.GetRoutes(req.Host)
 	if err != nil {
 		return c.sendError(req.Host, "", "", err)
 	}
-	return ctx.JSON(200, map[string][]apigw.Route{"routes": routes})
-}
 
-func (c adminController) AddDomainRoute(ctx *ship.Context) (err error) {
-	var r struct {
-		apigw.Route
-		Backends backend.Backends `json:"backends"`
+	rs := make([]RouteConfig, len(routes))
+	for i, route := range routes {
+		rs[i] = newRouteConfig(route)
+		rs[i].ForwarderConfig = rs[i].ForwarderConfig.MaskSecrets()
 	}
+	return ctx.JSON(200, RouteConfigsResponse{Routes: rs})
+}
 
+func (c adminController) AddDomainRoute(ctx *ship.Context) (err error) {
+	var r RouteConfig
 	if err = ctx.Bind(&r); err != nil {
 		return ship.ErrBadRequest.New(err)
 	} else if r.Path == "" || r.Method == "" {
 		return ship.ErrBadRequest.Newf("missing path or method")
+	} else if err = c.authorize(ctx, auth.RoleRouteEditor, r.Host); err != nil {
+		return
 	}
 
-	backends, err := r.Backends.Backends(r.Route)
-	if err != nil {
+	if err = addRoute(lb.DefaultGateway, r); err != nil {
 		return ship.ErrBadRequest.New(err)
 	}
+	return
+}
 
-	r.Forwarder = backend.NewForwarder(r.Name(), 0)
-	if r.Route, err = lb.DefaultGateway.RegisterRoute(r.Route); err != nil {
+func (c adminController) UpdateDomainRoute(ctx *ship.Context) (err error) {
+	var r RouteConfig
+	if err = ctx.Bind(&r); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if r.Path == "" || r.Method == "" {
+		return ship.ErrBadRequest.Newf("missing path or method")
+	} else if err = c.authorize(ctx, auth.RoleRouteEditor, r.Host); err != nil {
+		return
 	}
 
-	r.Route.Forwarder.(lb.Forwarder).AddBackends(backends...)
-	return
+	switch err = updateRoute(lb.DefaultGateway, r); err {
+	case nil:
+		return
+	case apigw.ErrNoHost, apigw.ErrNoRoute:
+		return c.sendError(r.Host, r.Path, r.Method, err)
+	default:
+		return ship.ErrBadRequest.New(err)
+	}
 }
 
 func (c adminController) DelDomainRoute(ctx *ship.Context) (err error) {
@@ -315,6 +750,8 @@ func (c adminController) DelDomainRoute(ctx *ship.Context) (err error) {
 		return ship.ErrBadRequest.New(err)
 	} else if r.Path == "" || r.Method == "" {
 		return ship.ErrBadRequest.Newf("missing path or method")
+	} else if err = c.authorize(ctx, auth.RoleRouteEditor, r.Host); err != nil {
+		return
 	}
 
 	if _, err = lb.DefaultGateway.UnregisterRoute(r); err != nil {
@@ -325,13 +762,11 @@ func (c adminController) DelDomainRoute(ctx *ship.Context) (err error) {
 }
 
 func (c adminController) GetAllDomainRouteBackends(ctx *ship.Context) (err error) {
-	var req struct {
-		Host   string `query:"host" validate:"zero|hostname_rfc1123"`
-		Path   string `query:"path" validate:"required"`
-		Method string `query:"method" validate:"required"`
-	}
+	var req RouteQueryRequest
 	if err = ctx.BindQuery(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleViewer, req.Host); err != nil {
+		return
 	}
 
 	bs, err := lb.DefaultGateway.GetRouteBackends(req.Host, req.Path, req.Method)
@@ -354,6 +789,7 @@ func (c adminController) GetAllDomainRouteBackends(ctx *ship.Context) (err error
 
 		backends[i] = backend.Backend{
 			Type:     b.Type(),
+			Weight:   backend.NewBackend(b).Weight,
 			Metadata: b.MetaData(),
 			RetryNum: hc.RetryNum,
 			Interval: interval,
@@ -361,18 +797,15 @@ func (c adminController) GetAllDomainRouteBackends(ctx *ship.Context) (err error
 		}
 	}
 
-	return ctx.JSON(200, map[string]interface{}{"backends": backends})
+	return ctx.JSON(200, BackendsResponse{Backends: backends})
 }
 
 func (c adminController) AddDomainRouteBackend(ctx *ship.Context) (err error) {
-	var req struct {
-		Host     string           `json:"host" validate:"zero|hostname_rfc1123"`
-		Path     string           `json:"path" validate:"required"`
-		Method   string           `json:"method" validate:"required"`
-		Backends backend.Backends `json:"backends"`
-	}
+	var req RouteBackendsRequest
 	if err = ctx.Bind(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleRouteEditor, req.Host); err != nil {
+		return
 	} else if len(req.Backends) == 0 {
 		return
 	}
@@ -388,24 +821,59 @@ func (c adminController) AddDomainRouteBackend(ctx *ship.Context) (err error) {
 }
 
 func (c adminController) DelDomainRouteBackend(ctx *ship.Context) (err error) {
-	var req struct {
-		Host     string           `json:"host" validate:"zero|hostname_rfc1123"`
-		Path     string           `json:"path" validate:"required"`
-		Method   string           `json:"method" validate:"required"`
-		Backends backend.Backends `json:"backends"`
-	}
+	var req RouteBackendsRequest
 	if err = ctx.Bind(&req); err != nil {
 		return ship.ErrBadRequest.New(err)
+	} else if err = c.authorize(ctx, auth.RoleRouteEditor, req.Host); err != nil {
+		return
 	} else if len(req.Backends) == 0 {
 		return
 	}
 
+	drain, err := parseDrain(req.Drain)
+	if err != nil {
+		return ship.ErrBadRequest.New(err)
+	}
+
 	route := apigw.NewRoute(req.Host, req.Path, req.Method)
-	backends, err := req.Backends.Backends(route)
+	addrs, err := req.Backends.Addrs(route)
 	if err != nil {
 		return ship.ErrBadRequest.New(err)
 	}
 
-	err = lb.DefaultGateway.DelRouteBackends(req.Host, req.Path, req.Method, backends...)
-	return c.sendError(req.Host, req.Path, req.Method, err)
+	forwarder, err := lb.DefaultGateway.GetRouteForwarder(req.Host, req.Path, req.Method)
+	if err != nil {
+		if drain < 0 { // Deleting the backends of the missing route does nothing.
+			return nil
+		}
+		return c.sendError(req.Host, req.Path, req.Method, err)
+	}
+
+	backends := lookupBackends(forwarder.GetBackends(), addrs)
+	if drain < 0 {
+		forwarder.DelBackends(backends...)
+		return
+	}
+
+	f, ok := forwarder.(*backend.Forwarder)
+	if !ok {
+		return ship.ErrBadRequest.Newf("the route does not support to drain the backends")
+	}
+	f.DrainBackends(drain, backends...)
+	return
+}
+
+// parseDrain parses the duration to drain the backends.
+//
+// If s is empty, return -1 to delete the backends at once.
+func parseDrain(s string) (time.Duration, error) {
+	if s == "" {
+		return -1, nil
+	}
+
+	drain, err := time.ParseDuration(s)
+	if err == nil && drain < 0 {
+		err = fmt.Errorf("invalid drain duration '%s'", s)
+	}
+	return drain, err
 }
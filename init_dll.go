@@ -26,6 +26,7 @@ var (
 	middlewareDllDirEnvName = strings.ToUpper(appName) + "_MIDDLEWARE_DLL_DIRS"
 	pluginDllDirEnvName     = strings.ToUpper(appName) + "_PLUGIN_DLL_DIRS"
 	sdDllDirEnvName         = strings.ToUpper(appName) + "_SD_DLL_DIRS"
+	checkerDllDirEnvName    = strings.ToUpper(appName) + "_HEALTHCHECK_DLL_DIRS"
 )
 
 func init() {
@@ -39,6 +40,8 @@ func init() {
 				err = loadDLLsFromDirs(env[index+1:])
 			case sdDllDirEnvName:
 				err = loadDLLsFromDirs(env[index+1:])
+			case checkerDllDirEnvName:
+				err = loadDLLsFromDirs(env[index+1:])
 			}
 
 			if err != nil {
@@ -17,6 +17,8 @@ package main
 import (
 	"net/http"
 
+	"github.com/xgfone/apigateway/auth"
+	"github.com/xgfone/apigateway/backend"
 	"github.com/xgfone/apigw/forward/lb"
 	"github.com/xgfone/gconf/v5"
 	"github.com/xgfone/go-tools/v7/lifecycle"
@@ -36,21 +38,60 @@ var globalOpts = []gconf.Opt{
 
 var httpOpts = []gconf.Opt{
 	gconf.IntOpt("maxidleconnsperhost", "The maximum number of the idle connections per host.").D(100),
+	gconf.IntOpt("maxconnsperhost", "The maximum number of the total connections per host. If ZERO, it is not limited."),
 	gconf.DurationOpt("idleconntimeout", "The timeout of the idle connection.").D("30s"),
+	gconf.DurationOpt("dialtimeout", "The timeout to dial the connection to the backend.").D("30s"),
+	gconf.DurationOpt("responseheadertimeout", "The timeout to wait for the response header of the backend. If ZERO, it is not limited.").D("0s"),
+	gconf.BoolOpt("disablekeepalives", "If true, disable the HTTP keep-alive to the backends."),
+	gconf.BoolOpt("disablehttp2", "If true, disable the HTTP/2 to the https backends."),
+}
+
+var healthCheckOpts = []gconf.Opt{
+	gconf.DurationOpt("interval", "The default interval of the health check of the backends.").D("10s"),
+	gconf.DurationOpt("timeout", "The default timeout of the health check of the backends. If ZERO, it is not limited.").D("0s"),
+	gconf.IntOpt("retrynum", "The default number of the extra failed health checks before the backend is unhealthy."),
+	gconf.IntOpt("concurrency", "The maximum number of the health checks running concurrently. If ZERO, it is not limited."),
+	gconf.IntOpt("maxtransitions", "The maximum number of the recent health transitions kept for each endpoint.").D(100),
+	gconf.StrSliceOpt("execcommands", "The list of the absolute paths of the commands allowed by the exec health checker, which may be run with any arguments by the admin api. If empty, disable the exec health checker."),
 }
 
 func init() {
 	gconf.NewGroup("http").RegisterOpts(httpOpts...)
+	gconf.NewGroup("healthcheck").RegisterOpts(healthCheckOpts...)
 }
 
 func main() {
 	// Parse the CLI arguments and initialize the logging.
 	goapp.Init(appName, globalOpts)
 
-	// Initialize the http transport.
+	// Initialize the http transport, which is the default of the backends.
+	httpGroup := gconf.Group("http")
 	tp := http.DefaultTransport.(*http.Transport)
-	tp.MaxIdleConnsPerHost = gconf.Group("http").GetInt("maxidleconnsperhost")
-	tp.IdleConnTimeout = gconf.Group("http").GetDuration("idleconntimeout")
+	tp.MaxIdleConnsPerHost = httpGroup.GetInt("maxidleconnsperhost")
+	tp.IdleConnTimeout = httpGroup.GetDuration("idleconntimeout")
+	backend.DefaultTransportOptions = backend.TransportOptions{
+		MaxIdleConnsPerHost:   tp.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       httpGroup.GetInt("maxconnsperhost"),
+		IdleConnTimeout:       tp.IdleConnTimeout,
+		DialTimeout:           httpGroup.GetDuration("dialtimeout"),
+		ResponseHeaderTimeout: httpGroup.GetDuration("responseheadertimeout"),
+		KeepAlive:             !httpGroup.GetBool("disablekeepalives"),
+		HTTP2:                 !httpGroup.GetBool("disablehttp2"),
+	}
+
+	// Initialize the default settings of the health check.
+	group := gconf.Group("healthcheck")
+	err := backend.SetHealthCheckConfig(backend.HealthCheckConfig{
+		Interval:    group.GetDuration("interval"),
+		Timeout:     group.GetDuration("timeout"),
+		RetryNum:    group.GetInt("retrynum"),
+		Concurrency: group.GetInt("concurrency"),
+	})
+	if err != nil {
+		log.Fatal("fail to initialize the health check", log.E(err))
+	}
+	backend.MaxHealthTransitions = group.GetInt("maxtransitions")
+	backend.ExecHealthCheckCommands = group.GetStringSlice("execcommands")
 
 	// Initialize the api gateway instance.
 	gw := lb.DefaultGateway
@@ -64,6 +105,13 @@ func main() {
 	registerMiddlewares(gw.Gateway)
 	startServiceDiscoveries(gw.Gateway)
 
+	// Replay the persisted configuration in order of the hosts, the backend
+	// groups and the routes.
+	backend.DefaultForwarderMaxTimeout = gconf.MustDuration("maxtimeout")
+	loadConfigFromStore(gw)
+	initConfigRevisions(gw)
+	initEvents()
+
 	// Start the api manager server.
 	if maddr := gconf.MustString("manageraddr"); maddr != "" {
 		mapp := ship.Default()
@@ -71,10 +119,14 @@ func main() {
 		mapp.Signals = nil
 		mapp.Link(gw.Router().Runner)
 		mapp.Use(middleware.Logger(), router.Recover)
+		authenticators := getManagerAuthenticators()
+		mapp.Use(auditAuthFailure, auth.Middleware(authenticators...))
+		initManagerRBAC(len(authenticators) > 0)
+		initAuditor()
 		mapp.SetLogger(log.GetDefaultLogger())
 		router.AddRuntimeRoutes(mapp)
 		initAdminRouter(mapp)
-		go mapp.Start(maddr)
+		go startManager(mapp, maddr)
 	}
 
 	// Start the api gateway HTTP server.
@@ -0,0 +1,158 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xgfone/apigateway/auth"
+	"github.com/xgfone/gconf/v5"
+	"github.com/xgfone/goapp/log"
+	"github.com/xgfone/ship/v3"
+)
+
+var managerOpts = []gconf.Opt{
+	gconf.StrOpt("certfile", "The path of the certificate file to enable TLS for the api manager."),
+	gconf.StrOpt("keyfile", "The path of the private key file to enable TLS for the api manager."),
+	gconf.StrOpt("clientcafile", "The path of the CA file to verify the client certificates, which enables the client certificate authentication and requires TLS."),
+	gconf.StrSliceOpt("tokens", "The list of the static api tokens, each of which is the format 'CALLER:TOKEN'."),
+	gconf.StrSliceOpt("basicusers", "The list of the users of the HTTP basic authentication, each of which is the format 'USER:BCRYPT_HASH'."),
+	gconf.StrSliceOpt("authenticators", "The list of the names of the extra authenticators to be enabled."),
+	gconf.StrSliceOpt("roles", "The list of the roles granted to the callers, each of which is the format 'CALLER:ROLE:HOST_PATTERN', which is required when any authenticator is enabled."),
+	gconf.BoolOpt("insecure", "If true, allow to start the api manager without any authenticator, which serves the whole admin api to anyone without the access control."),
+}
+
+// rbac is the role-based access control of the api manager.
+var rbac = auth.NewRBAC()
+
+func init() { gconf.NewGroup("manager").RegisterOpts(managerOpts...) }
+
+func splitCredentials(name string, values []string) (map[string]string, error) {
+	ms := make(map[string]string, len(values))
+	for _, value := range values {
+		index := strings.IndexByte(value, ':')
+		if index < 1 || index == len(value)-1 {
+			return nil, fmt.Errorf("invalid %s '%s'", name, value)
+		}
+		ms[value[:index]] = value[index+1:]
+	}
+	return ms, nil
+}
+
+// getManagerAuthenticators returns the authenticators of the api manager
+// from the configuration.
+func getManagerAuthenticators() (as []auth.Authenticator) {
+	group := gconf.Group("manager")
+
+	if tokens := group.GetStringSlice("tokens"); len(tokens) > 0 {
+		ms, err := splitCredentials("token", tokens)
+		if err != nil {
+			log.Fatal("fail to parse the api tokens", log.E(err))
+		}
+
+		token2callers := make(map[string]string, len(ms))
+		for caller, token := range ms {
+			token2callers[token] = caller
+		}
+		as = append(as, auth.TokenAuthenticator(token2callers))
+	}
+
+	if users := group.GetStringSlice("basicusers"); len(users) > 0 {
+		ms, err := splitCredentials("basic user", users)
+		if err != nil {
+			log.Fatal("fail to parse the basic users", log.E(err))
+		}
+		as = append(as, auth.BasicAuthenticator(ms))
+	}
+
+	if group.GetString("clientcafile") != "" {
+		as = append(as, auth.CertAuthenticator())
+	}
+
+	for _, name := range group.GetStringSlice("authenticators") {
+		a := auth.GetAuthenticator(name)
+		if a == nil {
+			log.Fatalf("no the authenticator named '%s'", name)
+		}
+		as = append(as, a)
+	}
+
+	if len(as) == 0 {
+		if !group.GetBool("insecure") {
+			log.Fatalf("no authenticator for the api manager, please configure one or enable the option 'manager.insecure' explicitly")
+		}
+		log.Warnf("no authentication for the api manager, which is insecure")
+	}
+
+	return
+}
+
+// initManagerRBAC grants the roles to the callers from the configuration.
+//
+// If authenticated is true, the roles are required.
+func initManagerRBAC(authenticated bool) {
+	values := gconf.Group("manager").GetStringSlice("roles")
+	if authenticated && len(values) == 0 {
+		log.Fatalf("no roles for the api manager with the authentication")
+	}
+
+	for _, value := range values {
+		items := strings.SplitN(value, ":", 3)
+		if len(items) != 3 || items[0] == "" {
+			log.Fatalf("invalid role '%s'", value)
+		}
+
+		role, err := auth.ParseRole(items[1])
+		if err != nil {
+			log.Fatal("fail to parse the role", log.F("role", value), log.E(err))
+		} else if err = rbac.Grant(items[0], role, items[2]); err != nil {
+			log.Fatal("fail to grant the role", log.F("role", value), log.E(err))
+		}
+	}
+}
+
+// startManager starts the api manager server on the address.
+func startManager(mapp *ship.Ship, addr string) {
+	group := gconf.Group("manager")
+	certfile := group.GetString("certfile")
+	keyfile := group.GetString("keyfile")
+
+	if cafile := group.GetString("clientcafile"); cafile != "" {
+		if certfile == "" || keyfile == "" {
+			log.Fatalf("the client certificate authentication requires certfile and keyfile")
+		}
+
+		data, err := ioutil.ReadFile(cafile)
+		if err != nil {
+			log.Fatal("fail to read the client CA file", log.E(err))
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			log.Fatalf("no valid certificate in the client CA file '%s'", cafile)
+		}
+
+		mapp.Server.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
+
+	mapp.Start(addr, certfile, keyfile)
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openapi generates the OpenAPI 3 document from the Go types.
+package openapi
+
+import "strings"
+
+// Version is the version of the OpenAPI specification.
+const Version = "3.0.3"
+
+// Document is the OpenAPI document.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info is the metadata of the api.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Components holds the reusable schemas.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// PathItem is the operations of a path, which is keyed by the lower-case
+// http method, such as "get".
+type PathItem map[string]*Operation
+
+// Operation is an api operation of a path.
+type Operation struct {
+	Summary     string              `json:"summary,omitempty"`
+	OperationID string              `json:"operationId,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter is the parameter of the operation.
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the request body of the operation.
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is the response of the operation.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of the content.
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Schema is the JSON schema of the data.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Spec is the specification of an api operation, whose request and response
+// are described by the values of the Go types used by the handler.
+type Spec struct {
+	Summary string
+	Tags    []string
+
+	// Query is the struct whose fields tagged by "query" are the query
+	// parameters.
+	Query interface{}
+
+	// Body is the value of the JSON request body.
+	Body interface{}
+
+	// Response is the value of the JSON response body. If nil, the response
+	// has no body.
+	Response interface{}
+
+	// ResponseContentType is the content type of the response,
+	// which is "application/json" by default.
+	ResponseContentType string
+}
+
+// Generator is used to generate the OpenAPI document.
+type Generator struct {
+	doc     Document
+	schemas *schemaRegistry
+}
+
+// NewGenerator returns a new OpenAPI document generator.
+func NewGenerator(title, version string) *Generator {
+	schemas := newSchemaRegistry()
+	return &Generator{
+		schemas: schemas,
+		doc: Document{
+			OpenAPI:    Version,
+			Info:       Info{Title: title, Version: version},
+			Paths:      make(map[string]PathItem, 32),
+			Components: Components{Schemas: schemas.components},
+		},
+	}
+}
+
+// AddOperation adds the operation of the api described by the spec.
+func (g *Generator) AddOperation(method, path string, spec Spec) {
+	op := &Operation{
+		Summary:     spec.Summary,
+		OperationID: operationID(method, path),
+		Tags:        spec.Tags,
+		Parameters:  g.schemas.Parameters(spec.Query),
+		Responses: map[string]Response{
+			"default": {
+				Description: "The error message.",
+				Content:     map[string]MediaType{"text/plain": {Schema: &Schema{Type: "string"}}},
+			},
+		},
+	}
+
+	if spec.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"application/json": {Schema: g.schemas.Schema(spec.Body)},
+			},
+		}
+	}
+
+	if spec.Response == nil {
+		op.Responses["200"] = Response{Description: "OK"}
+	} else {
+		ct := spec.ResponseContentType
+		if ct == "" {
+			ct = "application/json"
+		}
+		op.Responses["200"] = Response{
+			Description: "OK",
+			Content:     map[string]MediaType{ct: {Schema: g.schemas.Schema(spec.Response)}},
+		}
+	}
+
+	item, ok := g.doc.Paths[path]
+	if !ok {
+		item = make(PathItem, 4)
+		g.doc.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// Document returns the generated OpenAPI document.
+func (g *Generator) Document() Document { return g.doc }
+
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, s := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '-' || r == '_' || r == '.'
+	}) {
+		b.WriteString(strings.ToUpper(s[:1]))
+		b.WriteString(s[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openapi
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+// schemaRegistry builds the schemas of the Go types, and registers the named
+// struct types as the reusable components.
+type schemaRegistry struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		components: make(map[string]*Schema, 32),
+		names:      make(map[reflect.Type]string, 32),
+	}
+}
+
+// Schema returns the schema of the type of the value.
+func (r *schemaRegistry) Schema(v interface{}) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return r.schema(reflect.TypeOf(v))
+}
+
+// Parameters returns the query parameters from the fields of the struct
+// tagged by "query".
+func (r *schemaRegistry) Parameters(v interface{}) (ps []Parameter) {
+	if v == nil {
+		return
+	}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	for i, _len := 0, t.NumField(); i < _len; i++ {
+		field := t.Field(i)
+		name := tagName(field.Tag.Get("query"))
+		if name == "" || name == "-" {
+			continue
+		}
+
+		schema := r.schema(field.Type)
+		schema.Enum = getEnum(field.Tag.Get("validate"))
+		ps = append(ps, Parameter{
+			Name:     name,
+			In:       "query",
+			Required: isRequired(field.Tag.Get("validate")),
+			Schema:   schema,
+		})
+	}
+
+	return
+}
+
+func (r *schemaRegistry) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64"}
+	case bytesType:
+		return &Schema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := r.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: r.schema(t.Elem())}
+
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.schema(t.Elem())}
+
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + r.component(t)}
+
+	default: // Such as interface{}, which may be any value.
+		return &Schema{}
+	}
+}
+
+// component registers the named struct type as a component and returns
+// its name.
+func (r *schemaRegistry) component(t reflect.Type) string {
+	if name, ok := r.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, exist := r.components[name]; exist {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+
+	// Register the name first to support the recursive types.
+	r.names[t] = name
+	r.components[name] = &Schema{}
+	*r.components[name] = *r.structSchema(t)
+	return name
+}
+
+func (r *schemaRegistry) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema, t.NumField())}
+	r.addFields(s, t)
+	return s
+}
+
+func (r *schemaRegistry) addFields(s *Schema, t reflect.Type) {
+	for i, _len := 0, t.NumField(); i < _len; i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := tagName(tag)
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				r.addFields(s, ft)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" { // Unexported
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+
+		schema := r.schema(field.Type)
+		if enum := getEnum(field.Tag.Get("validate")); len(enum) > 0 {
+			schema.Enum = enum
+		}
+
+		s.Properties[name] = schema
+		if isRequired(field.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func tagName(tag string) string {
+	if index := strings.IndexByte(tag, ','); index > -1 {
+		return tag[:index]
+	}
+	return tag
+}
+
+// isRequired reports whether the validate tag contains the rule "required".
+func isRequired(validate string) bool {
+	for _, rule := range strings.Split(validate, ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// getEnum returns the enum values of the rule "oneof" in the validate tag,
+// such as "zero|oneof=json yaml".
+func getEnum(validate string) []string {
+	for _, rules := range strings.Split(validate, ",") {
+		for _, rule := range strings.Split(rules, "|") {
+			if strings.HasPrefix(rule, "oneof=") {
+				return strings.Fields(rule[len("oneof="):])
+			}
+		}
+	}
+	return nil
+}
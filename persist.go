@@ -0,0 +1,111 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/xgfone/apigateway/store"
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/gconf/v5"
+	"github.com/xgfone/go-tools/v7/lifecycle"
+	"github.com/xgfone/goapp/log"
+)
+
+var storeOpts = []gconf.Opt{
+	gconf.StrOpt("type", fmt.Sprintf("The type of the store to persist the configuration. Availables: %v.", store.GetBuilders())).D("file"),
+	gconf.StrOpt("source", "The source of the store, such as the file path for the file store. If empty, the configuration won't be persisted."),
+}
+
+func init() { gconf.NewGroup("store").RegisterOpts(storeOpts...) }
+
+var configStore struct {
+	lock  sync.Mutex
+	store store.Store
+	last  []byte
+}
+
+// loadConfigFromStore initializes the configuration store and replays
+// the stored configuration into the gateway.
+//
+// The invalid items of the stored configuration, such as the routes whose
+// backends cannot be built with the current options, are logged and skipped,
+// so they are not persisted again after the next change. If the stored
+// configuration cannot be decoded, start without it, and do not persist
+// the configuration in order not to overwrite it.
+func loadConfigFromStore(gw *lb.Gateway) {
+	source := gconf.Group("store").GetString("source")
+	if source == "" {
+		return
+	}
+
+	_type := gconf.Group("store").GetString("type")
+	builder := store.GetBuilder(_type)
+	if builder == nil {
+		log.Fatalf("no the store typed '%s'", _type)
+	}
+
+	s, err := builder.New(source)
+	if err != nil {
+		log.Fatal("fail to create the store", log.F("type", _type), log.E(err))
+	}
+	lifecycle.Register(func() { s.Close() })
+
+	data, err := s.Load()
+	if err != nil {
+		log.Fatal("fail to load the configuration", log.F("store", _type), log.E(err))
+	}
+
+	if len(data) > 0 {
+		var conf GatewayConfig
+		if err = json.Unmarshal(data, &conf); err != nil {
+			log.Error("fail to decode the configuration, and disable the store",
+				log.F("store", _type), log.E(err))
+			return
+		}
+
+		for _, err := range applyGatewayConfig(gw, conf) {
+			log.Error("skip the invalid configuration", log.F("store", _type), log.E(err))
+		}
+	}
+
+	configStore.lock.Lock()
+	configStore.store = s
+	configStore.last = data
+	configStore.lock.Unlock()
+}
+
+// saveConfigToStore saves the configuration into the store if it has changed.
+func saveConfigToStore(conf GatewayConfig) {
+	configStore.lock.Lock()
+	defer configStore.lock.Unlock()
+	if configStore.store == nil {
+		return
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		log.Error("fail to encode the configuration", log.E(err))
+	} else if bytes.Equal(data, configStore.last) {
+		return
+	} else if err = configStore.store.Save(data); err != nil {
+		log.Error("fail to save the configuration", log.E(err))
+	} else {
+		configStore.last = data
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/xgfone/apigateway/auth"
+	"github.com/xgfone/apigw/forward/lb"
+	"github.com/xgfone/gconf/v5"
+	"github.com/xgfone/ship/v3"
+)
+
+var revisionOpts = []gconf.Opt{
+	gconf.IntOpt("maxrevisions", "The maximum number of the configuration revisions to be kept.").D(100),
+}
+
+func init() { gconf.RegisterOpts(revisionOpts...) }
+
+// getCaller returns the identifier of the caller of the admin api.
+//
+// If the caller is not authenticated, return the real client ip instead.
+func getCaller(ctx *ship.Context) string {
+	if caller := auth.GetCaller(ctx); caller != "" {
+		return caller
+	}
+	return ctx.RealIP()
+}
+
+// ConfigRevision is a revision of the configuration of the gateway.
+type ConfigRevision struct {
+	ID     uint64         `json:"id"`
+	Time   time.Time      `json:"time"`
+	Caller string         `json:"caller"`
+	Method string         `json:"method,omitempty"`
+	Path   string         `json:"path,omitempty"`
+	Config *GatewayConfig `json:"config,omitempty"`
+}
+
+// configRevisions is a bounded history of the configuration revisions.
+type configRevisions struct {
+	lock      sync.RWMutex
+	lastID    uint64
+	maxsize   int
+	revisions []ConfigRevision
+}
+
+var revisions = &configRevisions{maxsize: 100}
+
+// Add adds the configuration as a new revision if it is different from
+// the latest one, and reports whether it is added.
+func (rs *configRevisions) Add(r ConfigRevision) (ok bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if _len := len(rs.revisions); _len > 0 &&
+		reflect.DeepEqual(rs.revisions[_len-1].Config, r.Config) {
+		return false
+	}
+
+	rs.lastID++
+	r.ID = rs.lastID
+	rs.revisions = append(rs.revisions, r)
+	if _len := len(rs.revisions); rs.maxsize > 0 && _len > rs.maxsize {
+		rs.revisions = append([]ConfigRevision{}, rs.revisions[_len-rs.maxsize:]...)
+	}
+	return true
+}
+
+// Get returns the revision by the id.
+//
+// If the revision does not exist or has been discarded, return false.
+func (rs *configRevisions) Get(id uint64) (r ConfigRevision, ok bool) {
+	rs.lock.RLock()
+	for _, rev := range rs.revisions {
+		if rev.ID == id {
+			r, ok = rev, true
+			break
+		}
+	}
+	rs.lock.RUnlock()
+	return
+}
+
+// Latest returns the configuration of the latest revision.
+//
+// Return ZERO if there is no revision.
+func (rs *configRevisions) Latest() (conf GatewayConfig) {
+	rs.lock.RLock()
+	if _len := len(rs.revisions); _len > 0 {
+		conf = *rs.revisions[_len-1].Config
+	}
+	rs.lock.RUnlock()
+	return
+}
+
+// GetAll returns all the revisions, which does not contain the configurations.
+func (rs *configRevisions) GetAll() []ConfigRevision {
+	rs.lock.RLock()
+	revs := make([]ConfigRevision, len(rs.revisions))
+	for i, rev := range rs.revisions {
+		rev.Config = nil
+		revs[i] = rev
+	}
+	rs.lock.RUnlock()
+	return revs
+}
+
+// initConfigRevisions records the initial configuration as the first revision.
+func initConfigRevisions(gw *lb.Gateway) {
+	conf := exportGatewayConfig(gw)
+	revisions.maxsize = gconf.MustInt("maxrevisions")
+	revisions.Add(ConfigRevision{Time: time.Now(), Caller: "startup", Config: &conf})
+}
+
+// configUpdateLock serializes all the admin calls to update the gateway.
+var configUpdateLock sync.Mutex
+
+// recordConfigUpdate is a middleware to serialize the admin calls to update
+// the gateway, then publish the changes, record the configuration as a new
+// revision and save it into the store after the update succeeds.
+//
+// The changes are compared with the latest revision, and nothing is done
+// if the call fails or changes nothing.
+func recordConfigUpdate(next ship.Handler) ship.Handler {
+	return func(ctx *ship.Context) (err error) {
+		if ctx.Method() == http.MethodGet {
+			return next(ctx)
+		}
+
+		configUpdateLock.Lock()
+		defer configUpdateLock.Unlock()
+
+		if err = next(ctx); err != nil {
+			return
+		}
+
+		conf := exportGatewayConfig(lb.DefaultGateway)
+		changes := diffGatewayConfig(revisions.Latest(), conf)
+		if len(changes) == 0 {
+			return
+		}
+
+		publishConfigChanges(changes)
+		revisions.Add(ConfigRevision{
+			Time:   time.Now(),
+			Caller: getCaller(ctx),
+			Method: ctx.Method(),
+			Path:   ctx.Path(),
+			Config: &conf,
+		})
+		saveConfigToStore(conf)
+		return
+	}
+}
+
+// rollbackGatewayConfig rolls the gateway back to the configuration.
+//
+// If failing to apply the changes, it tries to restore the configuration
+// before rolling back.
+func rollbackGatewayConfig(gw *lb.Gateway, conf GatewayConfig) ([]ConfigChange, error) {
+	current := exportGatewayConfig(gw)
+	changes := diffGatewayConfig(current, conf)
+	if err := applyConfigChanges(gw, changes); err != nil {
+		applyConfigChanges(gw, diffGatewayConfig(exportGatewayConfig(gw), current))
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xgfone/apigateway/backend"
+	"github.com/xgfone/apigw/forward/lb"
+)
+
+func TestConfigRevisions(t *testing.T) {
+	conf1 := GatewayConfig{Hosts: []string{"www.example.com"}}
+	conf2 := GatewayConfig{Hosts: []string{"www.example.org"}}
+
+	rs := &configRevisions{maxsize: 2}
+	tests := []struct {
+		conf  GatewayConfig
+		added bool
+		ids   []uint64
+	}{
+		{conf1, true, []uint64{1}},
+		{conf1, false, []uint64{1}},
+		{conf2, true, []uint64{1, 2}},
+		{conf1, true, []uint64{2, 3}},
+		{conf1, false, []uint64{2, 3}},
+	}
+
+	for i, tt := range tests {
+		conf := tt.conf
+		if added := rs.Add(ConfigRevision{Config: &conf}); added != tt.added {
+			t.Errorf("%d: expect added %v, but got %v", i, tt.added, added)
+		}
+
+		var ids []uint64
+		for _, r := range rs.GetAll() {
+			ids = append(ids, r.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%d: expect the revisions %v, but got %v", i, tt.ids, ids)
+		}
+
+		if latest := rs.Latest(); !reflect.DeepEqual(latest, tt.conf) {
+			t.Errorf("%d: expect the latest %+v, but got %+v", i, tt.conf, latest)
+		}
+	}
+
+	if _, ok := rs.Get(1); ok {
+		t.Errorf("the discarded revision 1 is still got")
+	}
+}
+
+func TestRollbackGatewayConfig(t *testing.T) {
+	conf1 := GatewayConfig{
+		Hosts:  []string{"www.example.com"},
+		Routes: []RouteConfig{newTestRoute("www.example.com", "/path", "http://127.0.0.1:8001")},
+	}
+	conf2 := GatewayConfig{
+		Hosts:  []string{"www.example.com", "www.example.org"},
+		Routes: []RouteConfig{newTestRoute("www.example.org", "/path", "http://127.0.0.1:8002")},
+	}
+	invalid := GatewayConfig{
+		Hosts: []string{"www.example.com"},
+		Routes: []RouteConfig{func() RouteConfig {
+			r := newTestRoute("www.example.com", "/path")
+			r.Backends = backend.Backends{{Type: "unknown"}}
+			return r
+		}()},
+	}
+
+	gw := lb.NewGateway()
+	tests := []struct {
+		name    string
+		target  GatewayConfig
+		fail    bool
+		current GatewayConfig
+	}{
+		{"rollback to conf1", conf1, false, conf1},
+		{"rollback to conf2", conf2, false, conf2},
+		{"restore on failure", invalid, true, conf2},
+		{"rollback to empty", GatewayConfig{}, false, GatewayConfig{}},
+	}
+
+	for _, tt := range tests {
+		_, err := rollbackGatewayConfig(gw, tt.target)
+		if tt.fail && err == nil {
+			t.Errorf("%s: expect an error, but got nil", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		if changes := diffGatewayConfig(exportGatewayConfig(gw), tt.current); len(changes) > 0 {
+			t.Errorf("%s: expect no changes, but got %q", tt.name, getChangeStrings(changes))
+		}
+	}
+}
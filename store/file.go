@@ -0,0 +1,86 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+func init() {
+	RegisterBuilder(NewBuilder("file", func(source string) (Store, error) {
+		if source == "" {
+			return nil, errors.New("missing the file path")
+		}
+		return NewFileStore(source), nil
+	}))
+}
+
+// NewFileStore returns a new store based on the local file.
+//
+// The data is written into a temporary file in the same directory first,
+// then renamed to filename, so the file is never left half-written.
+func NewFileStore(filename string) Store { return &fileStore{filename: filename} }
+
+type fileStore struct {
+	lock     sync.Mutex
+	filename string
+}
+
+func (s *fileStore) Close() error { return nil }
+
+func (s *fileStore) Load() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := ioutil.ReadFile(s.filename)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *fileStore) Save(data []byte) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	dir, name := filepath.Split(s.filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	file, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return
+	}
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), s.filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return
+}
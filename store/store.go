@@ -0,0 +1,83 @@
+// Copyright 2021 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package store is used to persist the configuration of the gateway.
+package store
+
+import (
+	"fmt"
+	"io"
+)
+
+var builders = make(map[string]Builder, 4)
+
+// Store is used to persist the configuration of the gateway.
+type Store interface {
+	io.Closer
+
+	// Load returns the configuration data stored last time.
+	//
+	// If there is no configuration data, return (nil, nil).
+	Load() ([]byte, error)
+
+	// Save stores the configuration data, which replaces the old.
+	Save(data []byte) error
+}
+
+// Builder is used to build the store.
+type Builder interface {
+	Type() string
+	New(source string) (Store, error)
+}
+
+// RegisterBuilder registers the store builder.
+//
+// If the builder has been registered, it will panic.
+func RegisterBuilder(builder Builder) {
+	_type := builder.Type()
+	if _, ok := builders[_type]; ok {
+		panic(fmt.Errorf("the store builder typed '%s' has been registered", _type))
+	}
+	builders[_type] = builder
+}
+
+// UnregisterBuilder unregisters the store builder by the type.
+func UnregisterBuilder(_type string) { delete(builders, _type) }
+
+// GetBuilder returns the store builder by the type.
+//
+// Return nil if the store builder does not exist.
+func GetBuilder(_type string) Builder { return builders[_type] }
+
+// GetBuilders returns the type list of all the store builders.
+func GetBuilders() []string {
+	types := make([]string, 0, len(builders))
+	for _type := range builders {
+		types = append(types, _type)
+	}
+	return types
+}
+
+// NewBuilder returns a new store builder.
+func NewBuilder(_type string, new func(source string) (Store, error)) Builder {
+	return builder{typ: _type, new: new}
+}
+
+type builder struct {
+	typ string
+	new func(string) (Store, error)
+}
+
+func (b builder) Type() string                     { return b.typ }
+func (b builder) New(source string) (Store, error) { return b.new(source) }